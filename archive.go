@@ -0,0 +1,406 @@
+package data
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Package archives (tarballs).
+//
+// Archives are reproducible: the same package always yields the same
+// bytes. Entries are sorted (the Manifest first), and have fixed
+// modification times and owners. All entries are under one top-level
+// directory, <name>-<version>/, which is stripped on extraction.
+
+// Modification time of all archive entries.
+var ArchiveTime = time.Unix(0, 0).UTC()
+
+// Returns the archive filename for a dataset: <name>-<version>.tar.gz
+func ArchiveName(h *Handle) string {
+	name := h.Name
+	if len(h.Version) > 0 {
+		name += "-" + h.Version
+	}
+	return name + ArchiveSuffix
+}
+
+// Writes the pack (Datafile, Manifest, and all files) as an archive.
+// Files are verified against the Manifest as they are written.
+func (p *Pack) Archive(filename string) error {
+	if !p.manifest.Complete() {
+		return fmt.Errorf(ManifestIncompleteMsg)
+	}
+
+	if err := p.manifest.ValidatePaths(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path.Join(p.root, DatafileName)); err != nil {
+		return fmt.Errorf(`Datafile missing. Try running 'data pack make'`)
+	}
+
+	name := ArchiveName(p.datafile.Handle())
+	if len(filename) == 0 {
+		filename = name
+	}
+	prefix := strings.TrimSuffix(name, ArchiveSuffix)
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	a := newArchiveWriter(f, p.root, prefix)
+	err = a.WritePack(p.manifest)
+	if cerr := a.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return err
+	}
+
+	pOut("data pack: archived %d files (%s) to %s\n", a.files,
+		humanBytes(a.size), filename)
+	return nil
+}
+
+// Extracts the archive to dst, and verifies every file against the
+// archive's Manifest. On failure, dst is removed.
+func UnarchivePack(filename string, dst string) error {
+	if len(dst) == 0 {
+		dst = strings.TrimSuffix(path.Base(filename), ArchiveSuffix)
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("data pack: %s already exists.", dst)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	x, err := extractArchiveTo(f, dst)
+	if err == nil {
+		err = x.Verify()
+	}
+	if err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+
+	pOut("data pack: unarchived %d files to %s. All checksums pass.\n",
+		len(x.Files), dst)
+	return nil
+}
+
+type archiveWriter struct {
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	root   string // dataset directory ("" for the working directory)
+	prefix string
+
+	files int
+	size  int64
+}
+
+func newArchiveWriter(w io.Writer, root string,
+	prefix string) *archiveWriter {
+
+	gz := gzip.NewWriter(w) // (no name or modification time)
+	return &archiveWriter{gz: gz, tw: tar.NewWriter(gz), root: root,
+		prefix: prefix}
+}
+
+func (a *archiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+func (a *archiveWriter) header(name string, typ byte, mode int64) *tar.Header {
+	return &tar.Header{
+		Name:     a.prefix + "/" + name,
+		Typeflag: typ,
+		Mode:     mode,
+		ModTime:  ArchiveTime,
+	}
+}
+
+// Writes the Manifest, the Datafile (if not in the Manifest), and all
+// entries in the Manifest, sorted by path.
+func (a *archiveWriter) WritePack(mf *Manifest) error {
+	if err := a.writeFile(ManifestFileName, 0644, ""); err != nil {
+		return err
+	}
+
+	if _, found := mf.Files[DatafileName]; !found {
+		if err := a.writeFile(DatafileName, 0644, ""); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range mf.Records() {
+		mode := int64(0)
+		if m := r.FileMeta(); len(r.Mode) > 0 {
+			mode = int64(m.Mode.Perm())
+		}
+
+		var err error
+		switch r.Kind {
+		case "file":
+			if mode == 0 {
+				mode = 0644
+			}
+			err = a.writeFile(r.Path, mode, r.Hash)
+
+		case "dir":
+			if mode == 0 {
+				mode = 0755
+			}
+			err = a.tw.WriteHeader(a.header(r.Path+"/", tar.TypeDir, mode))
+
+		case "link":
+			h := a.header(r.Path, tar.TypeSymlink, 0777)
+			h.Linkname = r.Target
+			err = a.tw.WriteHeader(h)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the file at (manifest) path p, verifying it matches hash (if
+// given).
+func (a *archiveWriter) writeFile(p string, mode int64, hash string) error {
+	f, err := os.Open(localPath(path.Join(a.root, p)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h := a.header(p, tar.TypeReg, mode)
+	h.Size = info.Size()
+	if err := a.tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	sh := sha1.New()
+	if _, err := io.CopyN(io.MultiWriter(a.tw, sh), f, h.Size); err != nil {
+		return fmt.Errorf("data pack: archiving %s: %s", p, err)
+	}
+
+	if len(hash) > 0 {
+		if vh := fmt.Sprintf("%x", sh.Sum(nil)); vh != hash {
+			return fmt.Errorf(ArchiveChangedMsg, p, hash, vh)
+		}
+	}
+
+	dErr("data pack: archived %s\n", p)
+	a.files++
+	a.size += h.Size
+	return nil
+}
+
+// Contents of an extracted archive.
+type extractedArchive struct {
+	Dir   string
+	Files map[string]string // { path : hash }
+	Links map[string]string // { path : target }
+}
+
+// Extracts a (gzipped) archive into dst, natively. The top-level
+// directory of entries is stripped. Entry paths must be portable (see
+// NormalizePath), and may not be beneath symlinks, nor symlinks point
+// outside dst.
+func extractArchiveTo(r io.Reader, dst string) (*extractedArchive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return nil, err
+	}
+
+	x := &extractedArchive{
+		Dir:   dst,
+		Files: map[string]string{},
+		Links: map[string]string{},
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		// strip top-level directory
+		name := strings.TrimPrefix(h.Name, "./")
+		i := strings.Index(name, "/")
+		if i < 0 || i == len(name)-1 {
+			continue // the top-level directory itself
+		}
+
+		p, err := NormalizePath(name[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("data pack: unsafe archive entry: %s", err)
+		}
+
+		if err := x.checkParents(p); err != nil {
+			return nil, err
+		}
+
+		local := filepath.Join(dst, filepath.FromSlash(p))
+		mode := os.FileMode(h.Mode).Perm()
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(local, mode|0700)
+
+		case tar.TypeReg, tar.TypeRegA:
+			x.Files[p], err = extractFile(tr, local, mode)
+
+		case tar.TypeSymlink:
+			// (a new link may also lead earlier ones outside.)
+			x.Links[p] = h.Linkname
+			if e := escapingLink(x.Links); len(e) > 0 {
+				return nil, fmt.Errorf("data pack: unsafe archive entry: "+
+					"symlink points outside the dataset: %s -> %s", e,
+					x.Links[e])
+			}
+
+			if err = os.MkdirAll(filepath.Dir(local), 0777); err == nil {
+				err = os.Symlink(h.Linkname, local)
+			}
+
+		default:
+			err = fmt.Errorf("data pack: unsupported archive entry: %s", h.Name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		dErr("data pack: extracted %s\n", p)
+	}
+}
+
+// Ensures no parent of p is a symlink (entries could be written anywhere).
+func (x *extractedArchive) checkParents(p string) error {
+	for d := path.Dir(p); d != "."; d = path.Dir(d) {
+		info, err := os.Lstat(filepath.Join(x.Dir, filepath.FromSlash(d)))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("data pack: unsafe archive entry beneath symlink: %s", p)
+		}
+	}
+	return nil
+}
+
+// Writes r to path, returning the hash of its contents.
+func extractFile(r io.Reader, path string, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), f.Close()
+}
+
+// Verifies extracted files against the extracted Manifest: every file
+// must be present, with matching checksum.
+func (x *extractedArchive) Verify() error {
+	mpath := filepath.Join(x.Dir, ManifestFileName)
+	if _, err := os.Stat(mpath); err != nil {
+		return fmt.Errorf("data pack: archive has no manifest (%s).",
+			ManifestFileName)
+	}
+
+	mf := NewManifest(mpath)
+	if err := mf.ValidatePaths(); err != nil {
+		return err
+	}
+
+	mfmt := "data pack: check %.7s %s %s\n"
+	failed := 0
+	for p, hash := range mf.Files {
+		h, found := x.Files[p]
+		switch {
+		case !found:
+			pErr(mfmt, hash, p, "FAIL - not in archive")
+			failed++
+		case h != hash:
+			pErr(mfmt, hash, p, "FAIL")
+			failed++
+		default:
+			dOut(mfmt, hash, p, "PASS")
+		}
+	}
+
+	for p, target := range mf.Links {
+		if t, found := x.Links[p]; !found || t != target {
+			pErr("data pack: check link %s FAIL\n", p)
+			failed++
+		}
+	}
+
+	for p, _ := range x.Files {
+		_, found := mf.Files[p]
+		if !found && p != ManifestFileName && p != DatafileName {
+			pErr("Warning: %s is in the archive, but not in the manifest.\n", p)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("data pack: %d/%d checksums failed!", failed,
+			len(mf.Files)+len(mf.Links))
+	}
+	return nil
+}
+
+const ArchiveChangedMsg = `%s changed since the manifest was generated
+(expected %.7s, got %.7s). Run 'data pack make' to update the manifest.`
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// An archive of symlinks { path : target }, added in path order.
+func testArchive(t *testing.T, links map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range sortedLinks(links) {
+		h := &tar.Header{Name: "ds/" + name, Typeflag: tar.TypeSymlink,
+			Linkname: links[name], Mode: 0777}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	tests := []struct {
+		path   string
+		target string
+		ok     bool
+	}{
+		{"l", "a.txt", true},
+		{"d/l", "../a.txt", true},
+		{"l", "../outside", false},
+		{"d/l", "../../outside", false},
+		{"l", "/etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "data-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		r := testArchive(t, map[string]string{tt.path: tt.target})
+		x, err := extractArchiveTo(r, dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s -> %s: extracted %v (%v)", tt.path, tt.target,
+				err == nil, err)
+			continue
+		}
+
+		local := filepath.Join(dir, filepath.FromSlash(tt.path))
+		target, lerr := os.Readlink(local)
+		if tt.ok && (lerr != nil || target != tt.target ||
+			x.Links[tt.path] != tt.target) {
+			t.Errorf("%s -> %s: link %s (%v)", tt.path, tt.target, target, lerr)
+		}
+		if !tt.ok && lerr == nil {
+			t.Errorf("%s -> %s: link created", tt.path, tt.target)
+		}
+	}
+}
+
+func TestExtractArchiveSymlinkChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a is extracted first, while it is still contained.
+	r := testArchive(t, map[string]string{
+		"a":       "d1/d2/b/..",
+		"d1/d2/b": "../..",
+	})
+	if _, err := extractArchiveTo(r, dir); err == nil {
+		t.Error("symlink chain out of the dataset extracted")
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "d1", "d2", "b")); err == nil {
+		t.Error("escaping link created")
+	}
+}
@@ -0,0 +1,240 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Client API, for using data from Go programs.
+//
+// A Client is configured explicitly (ClientConfig): it does not read or
+// write ~/.dataconfig, nor prompt on stdin. Trusted signing keys are kept
+// in memory. Requests are cancelled with the context passed to each call.
+//
+//   c, err := data.NewClient(data.ClientConfig{Dir: "datasets"})
+//   plan, err := c.Get(ctx, "jbenet/foo@^1.0")
+//   r, err := c.Open(ctx, "jbenet/foo@1.2", "data/train.csv")
+//
+// Progress messages are printed to the Client's Output; silence them with
+// ClientConfig{Output: ioutil.Discard}.
+
+const DefaultIndexUrl = "http://datadex.io"
+
+type ClientConfig struct {
+	IndexUrl string // default: DefaultIndexUrl
+	User     string // for publishing
+	Token    string // auth token of User
+
+	// blobstore (S3 bucket) of the index. Required for indexes other
+	// than datadex.
+	BlobStore string
+
+	// directory datasets are installed in (default: working directory)
+	Dir string
+
+	// trusted signing keys { author : key }. Keys of authors not listed
+	// are trusted on first use (for the Client's lifetime).
+	TrustedKeys map[string]string
+
+	// where messages and progress are printed (default: as with the data
+	// command, see SetOutput)
+	Output io.Writer
+}
+
+type Client struct {
+	Dir   string
+	index *DataIndex
+}
+
+func NewClient(c ClientConfig) (*Client, error) {
+	if len(c.IndexUrl) == 0 {
+		c.IndexUrl = DefaultIndexUrl
+	}
+
+	user := c.User
+	if len(user) == 0 {
+		user = AnonymousUser
+	}
+
+	bucket, err := indexBlobStore(c.IndexUrl, c.BlobStore)
+	if err != nil {
+		return nil, err
+	}
+
+	h := newHttpClientWithUrl(c.IndexUrl, user, c.Token)
+	i, err := newDataIndex(indexUrlName(h), h, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	i.trusted = newTrustedKeys(c.TrustedKeys)
+	i.out = c.Output
+	return &Client{Dir: c.Dir, index: i}, nil
+}
+
+// Resolves dependencies deps (<author>/<name>[@<version range>]), and
+// theirs, transitively, into an install plan.
+func (c *Client) Resolve(ctx context.Context, deps ...string) (
+	*InstallPlan, error) {
+
+	d, err := parseDependencies(deps, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return ResolveDependencies(c.index.withContext(ctx), d, nil)
+}
+
+// Installs deps (and their dependencies) into the Client's Dir. Returns
+// the installed plan.
+func (c *Client) Get(ctx context.Context, deps ...string) (*InstallPlan,
+	error) {
+
+	plan, err := c.Resolve(ctx, deps...)
+	if err != nil {
+		return nil, err
+	}
+
+	di := c.index.withContext(ctx)
+	for _, s := range plan.Datasets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := di.installDataset(c.Dir, s.Handle(), s.Ref, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// Opens file p of dataset (<author>/<name>[@<version range>]), reading it
+// from the blobstore, without installing the dataset. The contents are
+// verified as they are read: Read returns an error at the end if they do
+// not match the manifest.
+func (c *Client) Open(ctx context.Context, dataset string, p string) (
+	io.ReadCloser, error) {
+
+	d, err := ParseDependency(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	di := c.index.withContext(ctx)
+	s, err := newDependencyResolver(di).selectVersion(d.Path,
+		[]*Dependency{d})
+	if err != nil {
+		return nil, err
+	}
+
+	h := s.Handle()
+	if err := di.verifySignature(h, s.Ref); err != nil {
+		return nil, err
+	}
+
+	mf, err := di.verifiedManifest(s.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching manifest for %v. %s",
+			h.Dataset(), err)
+	}
+
+	fh := mf.HashForPath(path.Clean(p))
+	if len(fh) == 0 {
+		return nil, fmt.Errorf("%s: no file %s", h.Dataset(), p)
+	}
+
+	r, err := di.remoteBlob(fh)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedReader{r: r, h: sha1.New(), hash: fh, path: p}, nil
+}
+
+// Publishes the (packed) dataset in dir: uploads its blobs, and publishes
+// its version. Run 'data pack make' first (or generate the Datafile and
+// Manifest otherwise). Publishing requires the Client's User and Token.
+func (c *Client) Publish(ctx context.Context, dir string) error {
+	p := newPackWithIndex(dir, c.index.withContext(ctx))
+	if err := p.checkPublishable(); err != nil {
+		return err
+	}
+
+	if err := p.Upload(); err != nil {
+		return err
+	}
+
+	return p.Publish(false)
+}
+
+// Returns the manifest ref, checking it matches ref (its hash, or merkle
+// root).
+func (i *DataIndex) verifiedManifest(ref string) (*Manifest, error) {
+	r, err := i.remoteBlob(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return verifyManifest(buf, ref)
+}
+
+// Parses manifest buf, checking it matches ref (its hash, or merkle root).
+func verifyManifest(buf []byte, ref string) (*Manifest, error) {
+	mf := NewManifest("")
+	if err := mf.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+
+	vh, err := readerHash(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if vh != ref && mf.Merkle {
+		vh = mf.MerkleRoot()
+	}
+
+	if vh != ref {
+		return nil, fmt.Errorf("manifest hash error (expected %s, got %s)",
+			ref, vh)
+	}
+	return mf, nil
+}
+
+// ReadCloser that checks the contents hash to hash, at EOF.
+type verifiedReader struct {
+	r    io.ReadCloser
+	h    hash.Hash
+	hash string
+	path string
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+
+	if err == io.EOF {
+		vh := fmt.Sprintf("%x", v.h.Sum(nil))
+		if !strings.EqualFold(vh, v.hash) {
+			return n, fmt.Errorf("%s: hash error (expected %s, got %s)",
+				v.path, v.hash, vh)
+		}
+	}
+	return n, err
+}
+
+func (v *verifiedReader) Close() error {
+	return v.r.Close()
+}
@@ -15,9 +15,14 @@ var Cmd_data = &commander.Command{
 Basic commands:
 
     get         Download and install dataset.
+    remove      Remove dataset from Datafile dependencies.
+    update      Update datasets within Datafile version ranges.
+    outdated    Show installed datasets with newer versions.
     list        List installed datasets.
     info        Show dataset information.
+    diff        Show changes between dataset versions.
     publish     Guided dataset publishing.
+    lint        Check Datafile and Manifest for problems.
 
 Tool commands:
 
@@ -41,10 +46,15 @@ Use "data help <command>" for more information about a command.
 		cmd_data_info,
 		cmd_data_list,
 		cmd_data_get,
+		cmd_data_remove,
+		cmd_data_update,
+		cmd_data_outdated,
+		cmd_data_diff,
 		cmd_data_manifest,
 		cmd_data_pack,
 		cmd_data_blob,
 		cmd_data_publish,
+		cmd_data_lint,
 		cmd_data_user,
 		cmd_data_commands,
 	},
@@ -52,7 +62,7 @@ Use "data help <command>" for more information about a command.
 }
 
 func dataCmd(c *commander.Command, args []string) error {
-	pOut(c.Long)
+	pOut("%s", c.Long)
 	return nil
 }
 
@@ -17,7 +17,7 @@ func main() {
 		if len(err.Error()) > 0 {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
-		os.Exit(1)
+		os.Exit(data.ExitCode(err))
 	}
 	return
 }
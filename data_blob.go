@@ -2,12 +2,16 @@ package data
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha1"
 	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
+	"hash"
 	"io"
 	"os"
 	"path"
+	"sync"
 )
 
 var cmd_data_blob = &commander.Command{
@@ -45,7 +49,8 @@ var cmd_data_blob = &commander.Command{
     data stores blobs in blobstores. Every local dataset has a
     blobstore (local caching with links TBI). Like in git, the blobs
     are stored safely in the blobstore (different directory) and can
-    be used to reconstruct any corrupted/deleted/modified dataset files.
+    be used to reconstruct any corrupted/deleted/modified dataset files
+    (see 'data pack repair').
 
   Remote Blobstores
 
@@ -212,6 +217,7 @@ type blobStore interface {
 	Has(key string) (bool, error)
 	Put(key string, value io.Reader) error
 	Get(key string) (io.ReadCloser, error)
+	Size(key string) (int64, error)
 	Url(key string) string
 }
 
@@ -226,8 +232,12 @@ func blobCmd(c *commander.Command, args []string) (blobPaths, error) {
 	// Use all blobs in the manifest if --all is passed in.
 	all := c.Flag.Lookup("all").Value.Get().(bool)
 	if all {
-		mf := NewDefaultManifest()
-		blobs = validBlobHashes(mf.Files)
+		var err error
+		blobs, err = manifestBlobPaths(ManifestFileName)
+		if err != nil {
+			return nil, err
+		}
+
 		if len(blobs) < 1 {
 			return nil, fmt.Errorf("%v: no blobs tracked in manifest.", c.FullName())
 		}
@@ -285,7 +295,7 @@ func blobShowCmd(c *commander.Command, args []string) error {
 		return err
 	}
 
-	return dataIndex.copyBlob(hash, os.Stdout)
+	return dataIndex.copyBlob("", hash, os.Stdout)
 }
 
 func blobHashCmd(c *commander.Command, args []string) error {
@@ -314,21 +324,31 @@ func blobCheckCmd(c *commander.Command, args []string) error {
 
 // Uploads all blobs to blobstore
 func putBlobs(blobs blobPaths) error {
-	blobs = validBlobHashes(blobs)
-
 	dataIndex, err := NewMainDataIndex()
 	if err != nil {
 		return err
 	}
 
+	return dataIndex.putBlobsAt("", blobs)
+}
+
+// Uploads all blobs of the dataset in directory root ("" for the working
+// directory) to the blobstore.
+func (dataIndex *DataIndex) putBlobsAt(root string, blobs blobPaths) error {
+	blobs = validBlobHashes(blobs)
+
 	// flip map, to skip dupes
 	flipped := map[string]string{}
-	for path, hash := range blobs {
-		flipped[hash] = path
+	for p, hash := range blobs {
+		flipped[hash] = p
 	}
 
-	for hash, path := range flipped {
-		err = dataIndex.putBlob(hash, path)
+	for hash, p := range flipped {
+		if err := dataIndex.err(); err != nil {
+			return err
+		}
+
+		err := dataIndex.putBlob(hash, path.Join(root, p))
 		if err != nil {
 			return err
 		}
@@ -339,13 +359,22 @@ func putBlobs(blobs blobPaths) error {
 
 // Downloads all blobs from blobstore
 func getBlobs(blobs blobPaths) error {
-	blobs = validBlobHashes(blobs)
-
 	dataIndex, err := NewMainDataIndex()
 	if err != nil {
 		return err
 	}
 
+	return dataIndex.getBlobsAt("", blobs, nil)
+}
+
+// Downloads all blobs from blobstore into the dataset in directory root
+// ("" for the working directory), reporting progress using blob sizes
+// { hash : size }, if given.
+func (dataIndex *DataIndex) getBlobsAt(root string, blobs blobPaths,
+	sizes map[string]int64) error {
+
+	blobs = validBlobHashes(blobs)
+
 	// group map, to copy dupes
 	grouped := map[string][]string{}
 	for path, hash := range blobs {
@@ -353,18 +382,32 @@ func getBlobs(blobs blobPaths) error {
 		grouped[hash] = append(g, path)
 	}
 
+	var total, done int64
+	for hash, _ := range grouped {
+		total += sizes[hash]
+	}
+
 	for hash, paths := range grouped {
+		if err := dataIndex.err(); err != nil {
+			return err
+		}
 
 		// download one blob
-		err = dataIndex.getBlob(hash, paths[0])
+		err := dataIndex.getBlobAt(root, hash, paths[0])
 		if err != nil {
 			return err
 		}
 
+		if total > 0 {
+			done += sizes[hash]
+			dataIndex.pErr("    %s / %s (%d%%)\n", humanBytes(done),
+				humanBytes(total), done*100/total)
+		}
+
 		// copy what we got to others
-		for _, path := range paths[1:] {
-			pErr("copy blob %.7s %s\n", hash, path)
-			err := copyFile(paths[0], path)
+		for _, p := range paths[1:] {
+			dataIndex.pErr("copy blob %.7s %s\n", hash, p)
+			err := copyFile(path.Join(root, paths[0]), path.Join(root, p))
 			if err != nil {
 				return err
 			}
@@ -447,7 +490,7 @@ func (i *DataIndex) putBlob(hash string, fpath string) error {
 		return fmt.Errorf("put blob %.7s - error: no path supplied", hash)
 	}
 
-	fpath = path.Clean(fpath)
+	fpath = localPath(path.Clean(fpath))
 
 	// first, check the blobstore doesn't already have it.
 	exists, err := i.hasBlob(hash)
@@ -456,13 +499,13 @@ func (i *DataIndex) putBlob(hash string, fpath string) error {
 	}
 
 	if exists {
-		pErr("put blob %.7s %s - exists\n", hash, fpath)
+		i.pErr("put blob %.7s %s - exists\n", hash, fpath)
 		return nil
 	}
 
 	// must verify hash before uploading (for integrity).
 	// (note that there is a TOCTTOU bug here, so not safe. just helps.)
-	vh, err := hashFile(fpath)
+	vh, err := blobFileHash(fpath)
 	if err != nil {
 		return err
 	}
@@ -472,7 +515,7 @@ func (i *DataIndex) putBlob(hash string, fpath string) error {
 		return fmt.Errorf(m, fpath, hash, vh)
 	}
 
-	pErr("put blob %.7s %s - uploading\n", hash, fpath)
+	i.pErr("put blob %.7s %s - uploading\n", hash, fpath)
 
 	f, err := os.Open(fpath)
 	if err != nil {
@@ -496,26 +539,70 @@ func (i *DataIndex) putBlob(hash string, fpath string) error {
 
 // DataIndex extension to handle getting blob
 func (i *DataIndex) getBlob(hash string, fpath string) error {
+	return i.getBlobAt("", hash, fpath)
+}
+
+// Gets blob hash into (manifest) path p of the dataset in directory root
+// ("" for the working directory).
+func (i *DataIndex) getBlobAt(root string, hash string, p string) error {
 
 	// disallow empty paths
-	if len(fpath) == 0 {
+	if len(p) == 0 {
 		return fmt.Errorf("get blob %.7s - error: no path supplied", hash)
 	}
 
-	fpath = path.Clean(fpath)
+	i.pErr("get blob %.7s %s\n", hash, path.Clean(p))
+	fpath := path.Join(root, p)
+
+	// replace (not overwrite) any file there: it may be hard linked (see
+	// installStage.Seed).
+	if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
-	pErr("get blob %.7s %s\n", hash, fpath)
 	w, err := createFile(fpath)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
 
-	return i.copyBlob(hash, w)
+	// hash contents as they are written, to verify them.
+	hw := &hashWriter{w, sha1.New()}
+	err = i.copyBlob(root, hash, hw)
+	if err != nil {
+		return err
+	}
+
+	vh := fmt.Sprintf("%x", hw.h.Sum(nil))
+	if vh != hash && path.Clean(p) == path.Clean(ManifestFileName) {
+		// merkle manifests are named by root, not contents.
+		if mf := NewManifest(fpath); mf.Merkle {
+			vh = mf.MerkleRoot()
+		}
+	}
+
+	if vh != hash {
+		os.Remove(fpath)
+		m := "get blob: %s hash error (expected %s, got %s)"
+		return fmt.Errorf(m, fpath, hash, vh)
+	}
+
+	return nil
+}
+
+// WriteCloser that hashes everything written through it.
+type hashWriter struct {
+	io.WriteCloser
+	h hash.Hash
 }
 
-func (i *DataIndex) copyBlob(hash string, w io.WriteCloser) error {
-	r, err := i.findBlob(hash)
+func (w *hashWriter) Write(p []byte) (int, error) {
+	w.h.Write(p)
+	return w.WriteCloser.Write(p)
+}
+
+func (i *DataIndex) copyBlob(root string, hash string, w io.WriteCloser) error {
+	r, err := i.findBlob(root, hash)
 	if err != nil {
 		return err
 	}
@@ -539,11 +626,17 @@ func (i *DataIndex) copyBlob(hash string, w io.WriteCloser) error {
 	return nil
 }
 
-func (i *DataIndex) findBlob(hash string) (io.ReadCloser, error) {
+// Returns blob hash: a (verified) local copy in the dataset in directory
+// root, if any, or from the remote blobstore.
+func (i *DataIndex) findBlob(root string, hash string) (io.ReadCloser, error) {
+
+	paths, err := manifestPathsForHash(path.Join(root, ManifestFileName), hash)
+	if err != nil {
+		return nil, err
+	}
 
-	mf := NewDefaultManifest()
-	paths := mf.PathsForHash(hash)
 	for _, p := range paths {
+		p = localPath(path.Join(root, p))
 		dOut("found local blob copy. verifying hash. %s\n", p)
 		h, err := hashFile(p)
 		if err != nil {
@@ -561,7 +654,73 @@ func (i *DataIndex) findBlob(hash string) (io.ReadCloser, error) {
 	}
 
 	dOut("no local blob copy. fetch from remote blobstore.\n")
-	return i.BlobStore.Get(BlobKey(hash))
+	return i.remoteBlob(hash)
+}
+
+// Returns blob hash from the remote blobstore (reads are cancelled with
+// the index's context).
+func (i *DataIndex) remoteBlob(hash string) (io.ReadCloser, error) {
+	return i.remoteGet(BlobKey(hash))
+}
+
+func (i *DataIndex) remoteGet(key string) (io.ReadCloser, error) {
+	if err := i.err(); err != nil {
+		return nil, err
+	}
+
+	r, err := i.BlobStore.Get(key)
+	if err != nil || i.ctx == nil {
+		return r, err
+	}
+	return newContextReader(i.ctx, r), nil
+}
+
+// Reads blob ref into f (e.g. a Manifest, or Datafile).
+func (i *DataIndex) readBlob(ref string, f *SerializedFile) error {
+	r, err := i.remoteBlob(ref)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return f.Read(r)
+}
+
+// ReadCloser that stops reading (and closes r) once ctx is done.
+type contextReader struct {
+	ctx  context.Context
+	r    io.ReadCloser
+	done chan struct{}
+	once sync.Once
+}
+
+func newContextReader(ctx context.Context, r io.ReadCloser) *contextReader {
+	c := &contextReader{ctx: ctx, r: r, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close() // unblocks reads.
+		case <-c.done:
+		}
+	}()
+	return c
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	if cerr := c.ctx.Err(); err != nil && cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+func (c *contextReader) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.r.Close()
 }
 
 // DataIndex extension to check if blob exists
@@ -576,22 +735,36 @@ func (i *DataIndex) urlBlob(hash string) string {
 
 // Returns all paths associated with blob
 func allBlobPaths(hash string) ([]string, error) {
-	mf := NewDefaultManifest()
-
-	paths := mf.PathsForHash(hash)
-
-	mfh, err := mf.ManifestHash()
+	paths, err := manifestPathsForHash(ManifestFileName, hash)
 	if err != nil {
 		return []string{}, err
 	}
 
+	mfh, err := blobFileHash(ManifestFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return []string{}, err
+	}
+
 	if mfh == hash {
-		paths = append(paths, mf.Path)
+		paths = append(paths, path.Clean(ManifestFileName))
 	}
 
 	return paths, nil
 }
 
+// Returns the hash naming the blob stored in fpath. This is the hash of
+// its contents, except for merkle manifests (named by their merkle root).
+func blobFileHash(fpath string) (string, error) {
+	if fpath == path.Clean(ManifestFileName) {
+		mf := NewManifest(fpath)
+		if mf.Merkle {
+			return mf.ManifestHash()
+		}
+	}
+
+	return hashFile(fpath)
+}
+
 // Returns the blobstore key for blob
 func BlobKey(hash string) string {
 	return fmt.Sprintf("/blob/%s", hash)
@@ -9,7 +9,9 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // WARNING: the config format will be ini eventually. Go parsers
@@ -52,6 +54,7 @@ func init() {
 
 func configCmd(c *commander.Command, args []string) error {
 	if c.Flag.Lookup("show").Value.Get().(bool) {
+		loadConfig()
 		return printConfig(&Config)
 	}
 
@@ -93,6 +96,10 @@ func configEditor() error {
 		ed = "nano"
 	}
 
+	if err := ensureConfigFile(); err != nil {
+		return err
+	}
+
 	ed, args := execCmdArgs(ed, []string{globalConfigFile})
 	cmd := exec.Command(ed, args...)
 	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
@@ -107,7 +114,14 @@ func ConfigGetString(key string, default_ string) string {
 	return fmt.Sprintf("%s", val)
 }
 
+// guards Config (commands may run concurrently, e.g. installs).
+var configLock sync.RWMutex
+
 func ConfigGet(key string) interface{} {
+	loadConfig()
+	configLock.RLock()
+	defer configLock.RUnlock()
+
 	// struct -> map for dynamic walking
 	m := map[interface{}]interface{}{}
 	err := MarshalUnmarshal(Config, &m)
@@ -130,6 +144,10 @@ func ConfigGet(key string) interface{} {
 }
 
 func ConfigSet(key string, value string) error {
+	loadConfig()
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	// struct -> map for dynamic walking
 	m := map[interface{}]interface{}{}
 	if err := MarshalUnmarshal(Config, &m); err != nil {
@@ -190,39 +208,56 @@ var DefaultConfigText = `index:
     token: ""
 `
 
-// Load config file on statup
-func init() {
+var configOnce sync.Once
 
-	// alt config file path
-	if cf := os.Getenv("DATA_CONFIG"); len(cf) > 0 {
-		globalConfigFile = cf
-		pErr("Using config file path: %s\n", globalConfigFile)
-	}
+// Loads the config file, on first use (not on import: programs using the
+// package as a library, see Client, need no config file). If there is no
+// config file, the default config is used; it is written on first change.
+func loadConfig() {
+	configOnce.Do(func() {
+		configLock.Lock()
+		defer configLock.Unlock()
 
-	// expand ~/
-	usr, err := user.Current()
-	if err != nil {
-		panic("error: user context. " + err.Error())
-	}
-	dir := usr.HomeDir + "/"
-	globalConfigFile = strings.Replace(globalConfigFile, "~/", dir, 1)
+		// alt config file path
+		if cf := os.Getenv("DATA_CONFIG"); len(cf) > 0 {
+			globalConfigFile = cf
+			pErr("Using config file path: %s\n", globalConfigFile)
+		}
+
+		// expand ~/
+		if usr, err := user.Current(); err == nil {
+			dir := usr.HomeDir + "/"
+			globalConfigFile = strings.Replace(globalConfigFile, "~/", dir, 1)
+		}
+
+		err := error(nil)
+		if _, err = os.Stat(globalConfigFile); os.IsNotExist(err) {
+			err = Unmarshal(strings.NewReader(DefaultConfigText), &Config)
+		} else {
+			err = ReadConfigFile(globalConfigFile, &Config)
+		}
 
-	// install config if doesn't exist
-	if _, err := os.Stat(globalConfigFile); os.IsNotExist(err) {
-		err := WriteConfigFileText(globalConfigFile, DefaultConfigText)
 		if err != nil {
-			panic("error: failed to write config " + globalConfigFile +
-				". " + err.Error())
+			pErr("Error: failed to load config %s. %s\n", globalConfigFile, err)
 		}
-		pErr("Wrote new config file: %s\n", globalConfigFile)
+	})
+}
+
+// Writes the config file, if it does not exist yet.
+func ensureConfigFile() error {
+	loadConfig()
+	if _, err := os.Stat(globalConfigFile); !os.IsNotExist(err) {
+		return nil
 	}
 
-	// load config
-	err = ReadConfigFile(globalConfigFile, &Config)
-	if err != nil {
-		panic("error: failed to load config " + globalConfigFile +
-			". " + err.Error())
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if err := WriteConfigFile(globalConfigFile, &Config); err != nil {
+		return err
 	}
+
+	pErr("Wrote new config file: %s\n", globalConfigFile)
+	return nil
 }
 
 func WriteConfigFileText(filename string, text string) error {
@@ -295,6 +330,27 @@ func configGetIndex(name string) (map[string]string, error) {
 	return sidx, nil
 }
 
+// Returns the name of the configured index with url ("" if none).
+func configIndexWithUrl(url string) string {
+	indexes, _ := ConfigGet("index").(map[interface{}]interface{})
+	names := []string{}
+	for name, _ := range indexes {
+		if n, ok := name.(string); ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	url = newHttpClientWithUrl(url, "", "").Url
+	for _, n := range names {
+		if i, err := configGetIndex(n); err == nil &&
+			newHttpClientWithUrl(i["url"], "", "").Url == url {
+			return n
+		}
+	}
+	return ""
+}
+
 func isNamedUser(user string) bool {
 	return len(user) > 0 && user != AnonymousUser
 }
@@ -0,0 +1,257 @@
+package data
+
+import (
+	"fmt"
+	"github.com/jbenet/commander"
+	"sort"
+)
+
+var cmd_data_diff = &commander.Command{
+	UsageLine: "diff <dataset> <dataset>",
+	Short:     "Show changes between dataset versions.",
+	Long: `data diff - Show changes between dataset versions.
+
+    Compares the manifests of two published versions of a dataset, and
+    lists the files added, removed, changed, or renamed between them.
+    Only the manifests are fetched (via the dataset index); no dataset
+    files are downloaded. This helps judge an upgrade before running
+    'data get'.
+
+    For example:
+
+        data diff jbenet/foo@1.0 jbenet/foo@2.0
+
+    Output lines are prefixed with the kind of change:
+
+        A   file added
+        D   file removed (deleted)
+        M   file changed (modified)
+        R   file renamed (same contents, different path)
+
+Arguments:
+
+    <dataset>   dataset handle: <author>/<name>[@<version>]
+
+  `,
+	Run: diffCmd,
+}
+
+func diffCmd(c *commander.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%v: requires two <dataset> arguments.", c.FullName())
+	}
+
+	di, err := NewMainDataIndex()
+	if err != nil {
+		return err
+	}
+
+	ha := NewHandle(args[0])
+	hb := NewHandle(args[1])
+	for _, h := range []*Handle{ha, hb} {
+		if !h.Valid() {
+			return fmt.Errorf("%v: invalid dataset handle: %s", c.FullName(),
+				h.Dataset())
+		}
+	}
+
+	mfa, refa, err := di.manifestForHandle(ha)
+	if err != nil {
+		return err
+	}
+
+	mfb, refb, err := di.manifestForHandle(hb)
+	if err != nil {
+		return err
+	}
+
+	pOut("data diff: %s (%.7s) -> %s (%.7s)\n", ha.Dataset(), refa,
+		hb.Dataset(), refb)
+
+	if refa == refb {
+		pOut("No differences (same manifest).\n")
+		return nil
+	}
+
+	d := DiffManifests(mfa, mfb)
+	return d.Print(di, mfa, mfb)
+}
+
+// Fetches (only) the manifest of the published dataset named by handle.
+func (d *DataIndex) manifestForHandle(h *Handle) (*Manifest, string, error) {
+	ref, err := d.handleRef(h)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dOut("fetching manifest %.7s for %s\n", ref, h.Dataset())
+	mf, err := NewManifestWithRef(ref)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error fetching manifest for %v. %s",
+			h.Dataset(), err)
+	}
+
+	return mf, ref, nil
+}
+
+// A renamed file: same contents (hash), different path.
+type renamedPath struct {
+	From string
+	To   string
+	Hash string
+}
+
+// Changes between two manifests.
+type ManifestDiff struct {
+	Added   blobPaths
+	Removed blobPaths
+	Changed map[string][2]string // { path : [old-hash, new-hash] }
+	Renamed []renamedPath
+}
+
+func DiffManifests(a, b *Manifest) *ManifestDiff {
+	d := &ManifestDiff{
+		Added:   blobPaths{},
+		Removed: blobPaths{},
+		Changed: map[string][2]string{},
+	}
+
+	for path, ha := range a.Files {
+		hb, found := b.Files[path]
+		switch {
+		case !found:
+			d.Removed[path] = ha
+		case ha != hb:
+			d.Changed[path] = [2]string{ha, hb}
+		}
+	}
+
+	for path, hb := range b.Files {
+		if _, found := a.Files[path]; !found {
+			d.Added[path] = hb
+		}
+	}
+
+	// Pair up removed and added paths with the same contents as renames.
+	// Paths are paired in sorted order, so results are deterministic.
+	added := map[string][]string{}
+	for _, path := range sortedPaths(d.Added) {
+		h := d.Added[path]
+		added[h] = append(added[h], path)
+	}
+
+	for _, path := range sortedPaths(d.Removed) {
+		h := d.Removed[path]
+		to := added[h]
+		if len(to) == 0 {
+			continue
+		}
+
+		d.Renamed = append(d.Renamed, renamedPath{path, to[0], h})
+		added[h] = to[1:]
+		delete(d.Removed, path)
+		delete(d.Added, to[0])
+	}
+
+	return d
+}
+
+func (d *ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 &&
+		len(d.Changed) == 0 && len(d.Renamed) == 0
+}
+
+// Prints the diff. Blob sizes are taken from the manifests, if recorded,
+// or looked up in the index.
+func (d *ManifestDiff) Print(di *DataIndex, mfs ...*Manifest) error {
+	if d.Empty() {
+		pOut("No differences.\n")
+		return nil
+	}
+
+	// blob sizes, looked up once each. (-1 if unknown)
+	sizes := map[string]int64{}
+	for i := len(mfs) - 1; i >= 0; i-- {
+		for h, n := range mfs[i].HashSizes() {
+			sizes[h] = n
+		}
+	}
+
+	lookup := func(hash string) int64 {
+		if n, found := sizes[hash]; found {
+			return n
+		}
+
+		n, err := di.blobSize(hash)
+		if err != nil {
+			dErr("blob size %.7s: %s\n", hash, err)
+			n = -1
+		}
+		sizes[hash] = n
+		return n
+	}
+
+	size := func(hash string) string {
+		n := lookup(hash)
+		if n < 0 {
+			return "?"
+		}
+		return humanBytes(n)
+	}
+
+	var added, removed int64
+	sum := func(total *int64, hash string) {
+		if n := lookup(hash); n > 0 {
+			*total += n
+		}
+	}
+
+	for _, path := range sortedPaths(d.Added) {
+		h := d.Added[path]
+		pOut("A  %10s  %s\n", size(h), path)
+		sum(&added, h)
+	}
+
+	for _, path := range sortedPaths(d.Removed) {
+		h := d.Removed[path]
+		pOut("D  %10s  %s\n", size(h), path)
+		sum(&removed, h)
+	}
+
+	changed := []string{}
+	for path, _ := range d.Changed {
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+
+	for _, path := range changed {
+		hs := d.Changed[path]
+		pOut("M  %10s  %s (was %s)\n", size(hs[1]), path, size(hs[0]))
+		sum(&removed, hs[0])
+		sum(&added, hs[1])
+	}
+
+	for _, r := range d.Renamed {
+		pOut("R  %10s  %s -> %s\n", size(r.Hash), r.From, r.To)
+	}
+
+	pOut("\n%d added, %d removed, %d changed, %d renamed (+%s, -%s).\n",
+		len(d.Added), len(d.Removed), len(d.Changed), len(d.Renamed),
+		humanBytes(added), humanBytes(removed))
+	return nil
+}
+
+// DataIndex extension to get the size of a blob
+func (i *DataIndex) blobSize(hash string) (int64, error) {
+	return i.BlobStore.Size(BlobKey(hash))
+}
+
+// Returns the paths of a blobPaths map, sorted.
+func sortedPaths(blobs blobPaths) []string {
+	paths := []string{}
+	for path, _ := range blobs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -2,14 +2,17 @@ package data
 
 import (
 	"fmt"
+	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 )
 
 var cmd_data_get = &commander.Command{
-	UsageLine: "get [<dataset>]",
+	UsageLine: "get [<dataset>|<url>]",
 	Short:     "Download and install dataset.",
 	Long: `data get - Download and install dataset.
 
@@ -25,32 +28,98 @@ var cmd_data_get = &commander.Command{
         jbenet/foo@latest
         jbenet/foo@1.0
 
+    Or a url, of a dataset in another index, or of a dataset archive
+    (see 'data pack archive'):
+
+        http://<index>/<author>/<name>[@<version>]
+        https://example.com/foo-1.0.tar.gz
+
+    Index urls use the credentials, and blobstore, of the configured index
+    with that url (see 'data config'). Indexes other than datadex must be
+    configured, as their blobstore is not known otherwise.
+
+    Archives must contain a Datafile and Manifest: they are extracted, and
+    all files verified against the Manifest, before they are installed.
+    Archives of authors whose signing key you trust must be signed with it
+    (in datadex). (Datasets from urls are installed as given: their
+    dependencies are not installed, nor locked.)
+
     Loosely, data-get's process is:
 
     - Locate dataset Datafile and Manifest. (via provided argument).
     - Download Datafile and Manifest, to local Repository.
     - Download Blobs, listed in Manifest to local Repository.
     - Reconstruct Files, listed in Manifest.
-    - Install Files, into working directory.
+    - Install Files, into working directory. (Files are staged beside
+      the install directory, and swapped in once all are verified. If
+      anything fails, the previously installed copy is kept. Files of
+      the installed copy that are unchanged are not downloaded again.)
+
+    Dependencies: datasets' own dependencies (in their Datafiles) are
+    installed too. Dependencies may have version ranges, like
+    jbenet/foo@^1.2 (any 1.x version, at least 1.2), ~1.2, >=1.2 <2,
+    or 1.x. For each dataset, the latest version satisfying every range
+    on it is installed. If none does, data get explains which datasets
+    require which versions. The install plan is shown before downloading.
+
+    Lockfile: 'data get' (without a <dataset>) records the exact version
+    and manifest ref of every dependency it installs, and the index it
+    came from, in Datafile.lock. Later runs install exactly those, so
+    everyone with the same Datafile.lock gets the same bytes. Commit it
+    with the Datafile. Use --update to resolve dependencies again (e.g.
+    to pick up new versions) and rewrite Datafile.lock. Changing the
+    Datafile dependencies also resolves them again.
+
+    Saving: with --save, the datasets given are added to the Datafile's
+    dependencies (or updated, if already there), creating a Datafile if
+    needed. Versions given are saved as given; otherwise, the version
+    installed is saved as a compatible range (e.g. ^1.2). Other Datafile
+    fields and formatting are kept. Use 'data remove' to drop them again.
+
+    Sparse downloads: with --include and --exclude (comma-separated path
+    globs), only the matching files are downloaded and verified, though
+    the full Manifest (and the Datafile) are kept. For example:
+
+        data get jbenet/foo --include train/ --exclude '*.tmp'
+
+    A glob matches a path or any of its parent directories, and globs
+    without a '/' match names at any depth (e.g. '*.csv'). If the dataset
+    is a dependency in the Datafile, its globs are saved there (under
+    'sparse'), and used by later runs of 'data get'. Use --all to download
+    all files again (and clear saved globs).
 
   `,
-	Run: getCmd,
+	Run:  getCmd,
+	Flag: *flag.NewFlagSet("data-get", flag.ExitOnError),
+}
+
+func init() {
+	cmd_data_get.Flag.String("include", "", "only download matching paths")
+	cmd_data_get.Flag.String("exclude", "", "do not download matching paths")
+	cmd_data_get.Flag.Bool("all", false, "download all paths (clear saved globs)")
+	cmd_data_get.Flag.Bool("update", false, "resolve dependencies again, and update Datafile.lock")
+	cmd_data_get.Flag.Bool("save", false, "add datasets to the Datafile dependencies")
 }
 
 func getCmd(c *commander.Command, args []string) error {
 	var datasets []string
 
+	f, err := pathFilterFlags(c)
+	if err != nil {
+		return err
+	}
+	all := c.Flag.Lookup("all").Value.Get().(bool)
+
+	update := c.Flag.Lookup("update").Value.Get().(bool)
+	save := c.Flag.Lookup("save").Value.Get().(bool)
+
+	df, _ := NewDefaultDatafile()
 	if len(args) > 0 {
 		// if args, get those datasets.
 		datasets = args
 	} else {
 		// if no args, use Datafile dependencies
-		df, _ := NewDefaultDatafile()
-		for _, dep := range df.Dependencies {
-			if NewHandle(dep).Valid() {
-				datasets = append(datasets, dep)
-			}
-		}
+		datasets = df.Dependencies
 	}
 
 	if len(datasets) == 0 {
@@ -58,15 +127,108 @@ func getCmd(c *commander.Command, args []string) error {
 			"argument, or add dependencies in a Datafile.", c.FullName())
 	}
 
+	// urls are installed as given (not resolved).
+	urls, handles := splitUrls(datasets)
+
+	deps, err := parseDependencies(handles, "")
+	if err != nil {
+		return err
+	}
+
+	di, err := NewMainDataIndex()
+	if err != nil {
+		return err
+	}
+
+	// Datafile dependencies are locked in Datafile.lock.
+	locking := len(args) == 0
+	lf, err := NewDefaultLockfile()
+	if err != nil && locking && update {
+		pErr("Warning: ignoring %s. %s\n", LockfileName, err)
+	} else if err != nil && locking {
+		return fmt.Errorf("Error reading %s: %s\nRun 'data get --update' to "+
+			"regenerate it.", LockfileName, err)
+	}
+
+	// install the locked plan, or resolve dependencies (transitively).
+	// (locked versions are kept, unless updating.)
+	var plan *InstallPlan
+	if locking && !update && lf.Current(datasets) {
+		plan, err = lf.Plan(di)
+	} else if locking && !update {
+		if len(lf.Datasets) > 0 {
+			pErr("Datafile dependencies changed. Resolving them again.\n")
+		}
+		plan, err = ResolveDependencies(di, deps, lf.Versions())
+	} else {
+		plan, err = ResolveDependencies(di, deps, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if len(plan.Datasets) > 0 {
+		plan.Print()
+	}
+
+	// flags override (and replace) globs saved in the Datafile, for the
+	// datasets requested.
+	saveFilters := all || !f.Empty()
+	requested := map[string]bool{}
+	for _, d := range deps {
+		requested[d.Path] = true
+	}
+
 	installed_datasets := []string{}
-	for _, ds := range datasets {
-		ds, err := GetDataset(ds)
+	for _, s := range plan.Datasets {
+		h := s.Handle()
+		filter := df.DependencyFilter(h)
+		if saveFilters && requested[s.Path] {
+			filter = f
+		}
+
+		if err := di.installDataset("", h, s.Ref, filter); err != nil {
+			return err
+		}
+		installed_datasets = append(installed_datasets, h.Dataset())
+	}
+
+	for _, u := range urls {
+		ds, err := GetDataset(u, f)
 		if err != nil {
 			return err
 		}
 		installed_datasets = append(installed_datasets, ds)
 	}
 
+	if locking && !plan.Locked {
+		lf.Set(datasets, plan, di)
+		if err := lf.WriteFile(); err != nil {
+			return err
+		}
+		pErr("Wrote %s.\n", LockfileName)
+	}
+
+	// the Datafile is only changed once everything is installed.
+	if save && len(args) > 0 {
+		if err := saveDependencies(deps, urls, plan); err != nil {
+			return err
+		}
+		df, _ = NewDefaultDatafile()
+	}
+
+	if saveFilters {
+		for _, s := range plan.Datasets {
+			h := s.Handle()
+			if !requested[s.Path] || !df.HasDependency(h) {
+				continue
+			}
+
+			if err := SaveDependencyFilter(DatafileName, h, f); err != nil {
+				return err
+			}
+		}
+	}
+
 	if len(datasets) == 0 {
 		return nil
 	}
@@ -82,73 +244,287 @@ func getCmd(c *commander.Command, args []string) error {
 	return nil
 }
 
-func GetDataset(dataset string) (string, error) {
+// Splits datasets into urls, and handles (or dependencies).
+func splitUrls(datasets []string) (urls []string, handles []string) {
+	for _, ds := range datasets {
+		if isUrl(ds) {
+			urls = append(urls, ds)
+		} else {
+			handles = append(handles, ds)
+		}
+	}
+	return urls, handles
+}
+
+// Adds deps (and urls) to the Datafile, as installed in plan.
+func saveDependencies(deps []*Dependency, urls []string,
+	plan *InstallPlan) error {
+
+	saved := []string{}
+	for _, d := range deps {
+		dep := d.String()
+		if d.Range.Any() {
+			for _, s := range plan.Datasets {
+				if s.Path == d.Path {
+					dep = savedDependency(s)
+				}
+			}
+		}
+
+		saved = append(saved, dep)
+	}
+
+	for _, dep := range append(saved, urls...) {
+		if err := SaveDependency(DatafileName, dep); err != nil {
+			return err
+		}
+		pErr("Saved %s to %s.\n", dep, DatafileName)
+	}
+	pErr("\n")
+	return nil
+}
+
+// Returns a dependency on versions compatible with s: ^<version> (or
+// exactly <version>, if it is not numeric).
+func savedDependency(s *PlannedDataset) string {
+	if _, err := parseLooseVersion(s.Version); err != nil {
+		return s.Path + "@" + s.Version
+	}
+	return s.Path + "@^" + s.Version
+}
+
+// Downloads and installs dataset, only the files selected by f (all, if
+// nil).
+func GetDataset(dataset string, f *PathFilter) (string, error) {
+	switch {
+	case IsArchiveUrl(dataset):
+		return GetDatasetFromArchive(dataset, f)
+
+	case isUrl(dataset):
+		di, h, err := indexUrlHandle(dataset)
+		if err != nil {
+			return "", err
+		}
+		return h.Dataset(), di.getDataset("", h, f)
+	}
+
 	dataset = strings.ToLower(dataset)
 
 	// add lookup in datadex here.
 	h := NewHandle(dataset)
 	if h.Valid() {
 		// handle version can get resolved
-		err := GetDatasetFromIndex(h)
+		err := GetDatasetFromIndex(h, f)
 		return h.Dataset(), err
 	}
 
 	return "", fmt.Errorf("Unclear how to handle dataset identifier: %s", dataset)
 }
 
-func GetDatasetFromIndex(h *Handle) error {
+// Splits a dataset url, http[s]://<index>/<author>/<name>[@<version>],
+// into its index and dataset handle.
+func indexUrlHandle(s string) (*DataIndex, *Handle, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid dataset url %s: %s", s, err)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	n := len(parts)
+	if n < 2 {
+		return nil, nil, fmt.Errorf(InvalidDatasetUrlMsg, s)
+	}
+
+	h := NewHandle(strings.ToLower(parts[n-2] + "/" + parts[n-1]))
+	if !h.Valid() {
+		return nil, nil, fmt.Errorf(InvalidDatasetUrlMsg, s)
+	}
+
+	u.Path = strings.Join(parts[:n-2], "/")
+	u.RawQuery, u.Fragment = "", ""
+	di, err := NewDataIndexWithUrl(u.String())
+	return di, h, err
+}
+
+// Downloads the dataset archive at archiveUrl, and installs it, once all
+// its files are verified against its Manifest. Returns the dataset.
+func GetDatasetFromArchive(archiveUrl string, f *PathFilter) (string, error) {
+	if !f.Empty() {
+		pErr("Warning: archives are installed whole (ignoring %s).\n", f)
+	}
+	pErr("Downloading %s.\n", archiveUrl)
+
+	resp, err := httpGet(archiveUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// extract (and verify) beside the datasets, then stage it in place.
+	if err := os.MkdirAll(DatasetDir, 0777); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempDir(DatasetDir, ".archive-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := path.Join(tmp, "dataset")
+	x, err := extractArchiveTo(resp.Body, dir)
+	if err == nil {
+		err = x.Verify()
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error installing %s: %s", archiveUrl, err)
+	}
+
+	df, err := NewDatafile(path.Join(dir, DatafileName))
+	if err != nil || !df.Valid() {
+		return "", fmt.Errorf("Error installing %s: archive has no valid "+
+			"Datafile.", archiveUrl)
+	}
+
+	h := df.Handle()
+	di, err := NewMainDataIndex()
+	if err != nil {
+		return "", err
+	}
+
+	if err := di.verifyArchiveSignature(h, dir); err != nil {
+		return "", fmt.Errorf("Error installing %s: %s", archiveUrl, err)
+	}
+
+	s, err := newInstallStage(di.ctx, h.InstallPath(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	// (the staging directory is replaced by the extracted one.)
+	if err := os.Remove(s.Stage); err != nil {
+		s.Abort()
+		return "", err
+	}
+
+	if err := os.Rename(dir, s.Stage); err != nil {
+		s.Abort()
+		return "", err
+	}
+
+	if err := s.Commit(); err != nil {
+		return "", err
+	}
+
+	pErr("Verified %d files. All checksums pass.\n\n", len(x.Files))
+	return h.Dataset(), nil
+}
+
+// Verifies the signature of dataset h, extracted in dir, if the author's
+// key is trusted: archives may replace installed (signed) versions. The
+// signature is the one published in index di. (Keys are not trusted on
+// first use from archives.)
+func (di *DataIndex) verifyArchiveSignature(h *Handle, dir string) error {
+	if len(di.trustedKey(h.Author)) == 0 {
+		di.pErr("Warning: %s is not signature-checked (no trusted key for "+
+			"%s).\n", h.Dataset(), h.Author)
+		return nil
+	}
+
+	ref, err := NewManifest(path.Join(dir, ManifestFileName)).ManifestHash()
+	if err != nil {
+		return err
+	}
+	return di.verifySignature(h, ref)
+}
+
+func GetDatasetFromIndex(h *Handle, f *PathFilter) error {
 	di, err := NewMainDataIndex()
 	if err != nil {
 		return err
 	}
 
-	pErr("Downloading %s from %s (%s).\n", h.Dataset(), di.Name, di.Http.Url)
+	return di.getDataset("", h, f)
+}
 
+// Installs dataset h (resolving its version) in project directory root
+// ("" for the working directory).
+func (di *DataIndex) getDataset(root string, h *Handle, f *PathFilter) error {
 	// Get manifest ref
 	mref, err := di.handleRef(h)
 	if err != nil {
 		return err
 	}
 
-	// Prepare local directories
-	dir := h.InstallPath()
-	if err := os.RemoveAll(dir); err != nil {
+	return di.installDataset(root, h, mref, f)
+}
+
+// Installs dataset h, with manifest ref mref, in project directory root
+// ("" for the working directory), only the files selected by f (all, if
+// nil). Safe to call concurrently, for different datasets.
+func (di *DataIndex) installDataset(root string, h *Handle, mref string,
+	f *PathFilter) error {
+
+	di.pErr("Downloading %s from %s (%s).\n", h.Dataset(), di.Name,
+		di.Http.Url)
+
+	// Verify manifest ref signature
+	if err := di.verifySignature(h, mref); err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(dir, 0777); err != nil {
+	// Stage the install (the previous copy stays until it succeeds).
+	s, err := newInstallStage(di.ctx, path.Join(root, h.InstallPath()),
+		di.out)
+	if err != nil {
 		return err
 	}
 
-	cwd, err := os.Getwd()
-	if err != nil {
+	if err := di.downloadDataset(s, mref, f); err != nil {
+		s.Abort()
 		return err
 	}
 
-	if err := os.Chdir(dir); err != nil {
+	if err := s.Commit(); err != nil {
 		return err
 	}
 
-	// move back out
-	defer os.Chdir(cwd)
+	di.pErr("\n")
+	return nil
+}
+
+// Downloads dataset with manifest ref mref into staging directory s.
+func (di *DataIndex) downloadDataset(s *installStage, mref string,
+	f *PathFilter) error {
 
 	// download manifest
-	if err := downloadManifest(di, mref); err != nil {
+	// (getBlob verifies the manifest matches ref)
+	if err := di.getBlobAt(s.Stage, mref, ManifestFileName); err != nil {
 		return err
 	}
 
-	// download pack
-	p, err := NewPack()
+	// download pack, reusing the installed copy's files.
+	p := newPackWithIndex(s.Stage, di)
+	blobs, err := p.BlobPaths()
 	if err != nil {
 		return err
 	}
 
-	if err := p.Download(); err != nil {
+	// seed with the modes RestoreMeta will set (0: unchanged).
+	files := map[string]os.FileMode{}
+	for file, _ := range blobs {
+		files[file] = 0
+		if m, found := p.manifest.Meta[file]; found {
+			files[file] = m.Mode
+		}
+	}
+
+	if err := s.Seed(files); err != nil {
 		return err
 	}
 
-	pErr("\n")
-	return nil
+	// (files are verified as they are written.)
+	return p.Download(f)
 }
 
 func (d *DataIndex) handleRef(h *Handle) (string, error) {
@@ -177,10 +553,6 @@ func (d *DataIndex) handleRef(h *Handle) (string, error) {
 	return ref, nil
 }
 
-func downloadManifest(d *DataIndex, ref string) error {
-	return d.getBlob(ref, ManifestFileName)
-}
-
 func installedDatasetMessage(dataset string) error {
 	h := NewHandle(dataset)
 	fpath := DatafilePath(h.Dataset())
@@ -192,3 +564,7 @@ func installedDatasetMessage(dataset string) error {
 	pOut("Installed %s at %s\n", df.Dataset, path.Dir(fpath))
 	return nil
 }
+
+const InvalidDatasetUrlMsg = `Invalid dataset url %s. Should be
+http[s]://<index>/<author>/<name>[@<version>], or an archive url
+(ending in .tar.gz).`
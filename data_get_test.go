@@ -0,0 +1,70 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGetSaveFailedInstall(t *testing.T) {
+	orig := "dataset: a/b@1.0\ndependencies:\n  - c/d\n"
+	defer testDatasetDir(t)()
+	if err := ioutil.WriteFile(DatafileName, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	save := cmd_data_get.Flag.Lookup("save").Value
+	save.Set("true")
+	defer save.Set("false")
+
+	// (nothing listens on port 1: the download fails.)
+	err := getCmd(cmd_data_get, []string{"http://127.0.0.1:1/e-f.tar.gz"})
+	if err == nil {
+		t.Fatal("get succeeded")
+	}
+
+	buf, err := ioutil.ReadFile(DatafileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != orig {
+		t.Errorf("Datafile changed by failed get:\n%s", buf)
+	}
+}
+
+func TestVerifyArchiveSignature(t *testing.T) {
+	defer testDatasetDir(t, "ds/data.csv")()
+
+	mf := NewManifest("ds/" + ManifestFileName)
+	mf.Files["data.csv"] = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	if err := mf.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHttpClientWithUrl(DefaultIndexUrl, "", "")
+	i, err := newDataIndex(mainIndexName, h, blobStoreBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.trusted = newTrustedKeys(map[string]string{"a": "abcd"})
+	out := &bytes.Buffer{}
+	i.out = out
+
+	// (cancelled: no signature can be fetched.)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	di := i.withContext(ctx)
+
+	if err := di.verifyArchiveSignature(NewHandle("a/b@1.0"), "ds"); err == nil {
+		t.Error("archive of a trusted author installed unsigned")
+	}
+
+	if err := di.verifyArchiveSignature(NewHandle("c/d@1.0"), "ds"); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), "c/d@1.0 is not signature-checked") {
+		t.Errorf("no warning: %q", out.String())
+	}
+}
@@ -60,8 +60,8 @@ func (d *Handle) InstallPath() string {
 
 // order: rsplit @, split /, rsplit .
 func (d *Handle) SetDataset(s string) {
-	// no / is invalid
-	if strings.Index(s, "/") == 0 {
+	// no / (or nothing) is invalid
+	if len(s) == 0 || strings.Index(s, "/") == 0 {
 		return
 	}
 
@@ -1,11 +1,13 @@
 package data
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 type DataIndex struct {
@@ -15,34 +17,161 @@ type DataIndex struct {
 	// For now, use S3Store directly. clean up interface later.
 	// BlobStore blobStore
 	BlobStore *S3Store
+
+	// (set for Client indexes) requests are cancelled with ctx, trusted
+	// keys are kept in trusted, instead of the config, and messages are
+	// printed to out.
+	ctx     context.Context
+	trusted *trustedKeys
+	out     io.Writer
 }
 
 var mainDataIndex *DataIndex
+var mainDataIndexLock sync.Mutex
 
 const mainIndexName = "datadex"
 
 // why not use `func init()`? some commands don't need an index
 // is annoying to error out on an S3 key when S3 isn't needed.
 func NewMainDataIndex() (*DataIndex, error) {
+	mainDataIndexLock.Lock()
+	defer mainDataIndexLock.Unlock()
+
 	if mainDataIndex != nil {
 		return mainDataIndex, nil
 	}
 
-	i := &DataIndex{Name: mainIndexName}
+	h, err := NewHttpClient(mainIndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	mainDataIndex, err = newDataIndex(mainIndexName, h,
+		configIndexBlobStore(mainIndexName, blobStoreBucket))
+	return mainDataIndex, err
+}
+
+func newDataIndex(name string, h *HttpClient, bucket string) (*DataIndex,
+	error) {
+
+	i := &DataIndex{Name: name, Http: h}
 	err := error(nil)
 
-	i.Http, err = NewHttpClient(i.Name)
+	i.BlobStore, err = NewS3Store(bucket, i)
 	if err != nil {
 		return nil, err
 	}
+	return i, nil
+}
+
+// Returns a copy of the index, whose requests are cancelled with ctx.
+func (i *DataIndex) withContext(ctx context.Context) *DataIndex {
+	c := *i
+	h := *i.Http
+	h.ctx = ctx
+	c.Http = &h
+	c.ctx = ctx
+	if i.BlobStore != nil {
+		c.BlobStore = i.BlobStore.withContext(&c, ctx)
+	}
+	return &c
+}
+
+// Print messages and progress to the index's output (see Client).
+func (i *DataIndex) pErr(format string, a ...interface{}) {
+	wErr(i.output(), format, a...)
+}
+
+func (i *DataIndex) pOut(format string, a ...interface{}) {
+	wOut(i.output(), format, a...)
+}
+
+func (i *DataIndex) output() io.Writer {
+	if i == nil {
+		return nil
+	}
+	return i.out
+}
+
+// Returns the error of the index's context, if it is done.
+func (i *DataIndex) err() error {
+	if i.ctx == nil {
+		return nil
+	}
+	return i.ctx.Err()
+}
+
+// The datadex blobstore (S3 bucket). Blobs are fetched from the blobstore
+// directly, so other indexes must configure theirs (see indexBlobStore).
+const blobStoreBucket = "datadex.archives"
+
+// Returns the index at url: the configured index with that url (with its
+// credentials), or an anonymous one.
+func NewDataIndexWithUrl(url string) (*DataIndex, error) {
+	name := configIndexWithUrl(url)
+	if name == mainIndexName {
+		return NewMainDataIndex()
+	}
 
-	i.BlobStore, err = NewS3Store("datadex.archives", i)
+	if len(name) > 0 {
+		h, err := NewHttpClient(name)
+		if err != nil {
+			return nil, err
+		}
+
+		bucket, err := indexBlobStore(url, configIndexBlobStore(name, ""))
+		if err != nil {
+			return nil, err
+		}
+		return newDataIndex(name, h, bucket)
+	}
+
+	bucket, err := indexBlobStore(url, "")
 	if err != nil {
 		return nil, err
 	}
 
-	mainDataIndex = i
-	return mainDataIndex, nil
+	h := newHttpClientWithUrl(url, "", "")
+	return newDataIndex(indexUrlName(h), h, bucket)
+}
+
+// Returns the blobstore of the index at url: bucket, if configured, or
+// the datadex blobstore, for the datadex index. Other indexes' blobstores
+// are unknown.
+func indexBlobStore(url string, bucket string) (string, error) {
+	if len(bucket) > 0 {
+		return bucket, nil
+	}
+
+	u := newHttpClientWithUrl(url, "", "").Url
+	if u == newHttpClientWithUrl(DefaultIndexUrl, "", "").Url {
+		return blobStoreBucket, nil
+	}
+	return "", fmt.Errorf(IndexBlobStoreMsg, url, url)
+}
+
+const IndexBlobStoreMsg = `Blobstore of index %s unknown. Datasets' blobs are
+fetched from the index's blobstore: configure the index, and its blobstore,
+with:
+
+    data config index.<name>.url %s
+    data config index.<name>.blobstore <s3 bucket>
+`
+
+// Returns the configured blobstore of index name (index.<name>.blobstore),
+// or default_.
+func configIndexBlobStore(name string, default_ string) string {
+	i, err := configGetIndex(name)
+	if err != nil || len(i["blobstore"]) == 0 {
+		return default_
+	}
+	return i["blobstore"]
+}
+
+// Names an (unconfigured) index by its url: <host>[/<path>]
+func indexUrlName(h *HttpClient) string {
+	name := strings.TrimPrefix(h.BaseUrl, "http://")
+	return strings.TrimPrefix(name, "https://")
 }
 
 const HttpHeaderUser = "X-Data-User"
@@ -57,6 +186,8 @@ type HttpClient struct {
 	Url       string
 	User      string
 	AuthToken string
+
+	ctx context.Context // cancels requests, if set.
 }
 
 func NewHttpClient(index string) (*HttpClient, error) {
@@ -65,10 +196,14 @@ func NewHttpClient(index string) (*HttpClient, error) {
 		return nil, err
 	}
 
+	return newHttpClientWithUrl(i["url"], i["user"], i["token"]), nil
+}
+
+func newHttpClientWithUrl(url, user, token string) *HttpClient {
 	h := &HttpClient{
-		BaseUrl:   strings.ToLower(i["url"]),
-		User:      i["user"],
-		AuthToken: i["token"],
+		BaseUrl:   strings.TrimRight(strings.ToLower(url), "/"),
+		User:      user,
+		AuthToken: token,
 	}
 
 	// ensure url has protocol prefix
@@ -83,7 +218,7 @@ func NewHttpClient(index string) (*HttpClient, error) {
 		h.Url = h.Url + ApiUrlSuffix
 	}
 
-	return h, nil
+	return h
 }
 
 func (h HttpClient) SubUrl(path string) string {
@@ -127,6 +262,10 @@ func (h *HttpClient) Post(path string, body interface{}) (*http.Response, error)
 }
 
 func (h *HttpClient) DoRequest(req *http.Request) (*http.Response, error) {
+	if h.ctx != nil {
+		req = req.WithContext(h.ctx)
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
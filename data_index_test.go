@@ -0,0 +1,28 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestIndexBlobStore(t *testing.T) {
+	tests := []struct {
+		url      string
+		bucket   string
+		expected string // "" if unknown
+	}{
+		{"http://datadex.io", "", blobStoreBucket},
+		{"http://Datadex.io/", "", blobStoreBucket},
+		{"datadex.io", "", blobStoreBucket},
+		{"http://datadex.io", "other.archives", "other.archives"},
+		{"https://example.com/index", "example.archives", "example.archives"},
+		{"https://example.com/index", "", ""},
+	}
+
+	for _, tt := range tests {
+		bucket, err := indexBlobStore(tt.url, tt.bucket)
+		if bucket != tt.expected || (err == nil) != (len(tt.expected) > 0) {
+			t.Errorf("%s (%q): %q, expected %q (%v)", tt.url, tt.bucket, bucket,
+				tt.expected, err)
+		}
+	}
+}
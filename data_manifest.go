@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
+	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 const ManifestFileName = ".data/Manifest"
@@ -21,7 +26,9 @@ var cmd_data_manifest = &commander.Command{
     Generates and manipulates this dataset's manifest. The manifest
     is a mapping of { <path>: <checksum>}, and describes all files
     that compose a dataset. This mapping is generated by adding and
-    hashing (checksum) files.
+    hashing (checksum) files. Along with checksums, the manifest records
+    each file's size, permissions, and media type, as well as any empty
+    directories, so datasets can be reconstructed faithfully.
 
     Running data-manifest without arguments will generate (or patch)
     the manifest. Note that already hashed files will not be re-hashed
@@ -34,26 +41,63 @@ var cmd_data_manifest = &commander.Command{
       rm <file>       Removes <file> from manifest.
       hash <file>     Hashes <file> and adds checksum to manifest.
       check <file>    Verifies <file> checksum matches manifest.
+      proof <file>    Outputs proof that <file> is in the manifest.
+      verify-proof    Verifies a proof against a ref.
+      import <file>   Imports checksums from a sha1sum file or BagIt bag.
+      export          Exports manifest as a sha1sum file or BagIt bag.
 
     (use the --all flag to do it to all available files)
 
+    Files listed in the manifest but missing from the directory are
+    reported when generating. Stop tracking them with 'rm --missing'.
+
+    Symlinks are handled according to the manifest's symlink policy,
+    set with --symlinks (and recorded in the manifest):
+
+      follow          Store the contents of link targets (default).
+      link            Store links themselves; recreated on download.
+      error           Fail if any symlinks are found.
+
+    With --merkle, the manifest's hash (the dataset ref) is the root of a
+    Merkle tree over its entries, rather than the hash of the whole file.
+    This allows proving a single file belongs to a published version,
+    without the full manifest (see 'proof' and 'verify-proof').
+
+    Paths are stored in a portable form: relative, '/'-separated, and
+    unicode NFC normalized. Absolute paths and '..' are rejected. Paths
+    that differ only by case are reported by 'data pack make', as they
+    would collide on case-insensitive filesystems.
+
+    Manifests are stored as YAML, or, with --format stream, in a sorted,
+    line-oriented format, indexed (.data/Manifest.index) so they can be
+    generated, checked, and searched without loading them entirely into
+    memory. Use streams for datasets with many files (50,000 or more).
+    The format is recorded in the manifest, and kept until changed with
+    --format. Changing it changes the manifest's hash (the dataset ref),
+    unless made with --merkle.
+
     Loosely, data-manifest's process is:
 
     - List all files in the working directory.
     - Add files to the manifest (effectively tracking them).
     - Hash tracked files, adding checksums to the manifest.
   `,
-	Run: manifestCmd,
+	Run:  manifestCmd,
+	Flag: *flag.NewFlagSet("data-manifest", flag.ExitOnError),
 	Subcommands: []*commander.Command{
 		cmd_data_manifest_add,
 		cmd_data_manifest_rm,
 		cmd_data_manifest_hash,
 		cmd_data_manifest_check,
+		cmd_data_manifest_proof,
+		cmd_data_manifest_verify_proof,
+		cmd_data_manifest_import,
+		cmd_data_manifest_export,
 	},
 }
 
 var cmd_data_manifest_add = &commander.Command{
-	UsageLine: "add <file>",
+	UsageLine: "add [<file> | --all]",
 	Short:     "Adds <file> to manifest (does not hash).",
 	Long: `data manifest add - Adds <file> to manifest (does not hash).
 
@@ -73,13 +117,16 @@ Arguments:
 }
 
 var cmd_data_manifest_rm = &commander.Command{
-	UsageLine: "rm <file>",
+	UsageLine: "rm [<file> | --missing | --all]",
 	Short:     "Removes <file> from manifest.",
 	Long: `data manifest rm - Removes <file> from manifest.
 
     Removing files from the manifest stops tracking them. This command
     removes the given <file> (and hash) from the manifest, and exits.
 
+    With --missing, removes every tracked file that no longer exists
+    in the directory (e.g. files deleted after they were added).
+
     See 'data manifest'.
 
 Arguments:
@@ -92,13 +139,17 @@ Arguments:
 }
 
 var cmd_data_manifest_hash = &commander.Command{
-	UsageLine: "hash <file>",
+	UsageLine: "hash [<file> | --all | --rehash | --unhashed]",
 	Short:     "Hashes <file> and adds checksum to manifest.",
 	Long: `data manifest hash - Hashes <file> and adds checksum to manifest.
 
 		Hashing files in the manifest calculates the file checksums. This command
     hashes the given <file>, adds it to the manifest, and exits.
 
+    With --all, hashes every tracked file. With --rehash, hashes again
+    only the tracked files already hashed (e.g. after they were modified).
+    With --unhashed, hashes only the tracked files not yet hashed.
+
     See 'data manifest'.
 
 Arguments:
@@ -130,18 +181,131 @@ Arguments:
 	Flag: *flag.NewFlagSet("data-manifest-check", flag.ExitOnError),
 }
 
+var cmd_data_manifest_proof = &commander.Command{
+	UsageLine: "proof <file>",
+	Short:     "Outputs proof that <file> is in the manifest.",
+	Long: `data manifest proof - Outputs proof that <file> is in the manifest.
+
+    Outputs a compact proof that <file> (with its checksum) is part of
+    the manifest: the path from its entry to the manifest's Merkle root.
+    Anyone can check the proof against a published ref (for manifests
+    made with --merkle) using 'data manifest verify-proof', without the
+    full manifest.
+
+    See 'data manifest'.
+
+Arguments:
+
+    <file>   path of the file to prove.
+
+  `,
+	Run: manifestProofCmd,
+}
+
+var cmd_data_manifest_verify_proof = &commander.Command{
+	UsageLine: "verify-proof <proof> <ref>",
+	Short:     "Verifies a proof against a ref.",
+	Long: `data manifest verify-proof - Verifies a proof against a ref.
+
+    Verifies that the file entry in <proof> (output by 'data manifest
+    proof') belongs to the manifest with Merkle root <ref>. The <ref>
+    may also be a dataset handle, which is resolved using the index.
+
+    See 'data manifest'.
+
+Arguments:
+
+    <proof>  path of the proof file ('-' for stdin).
+    <ref>    manifest ref (hash), or <author>/<name>[@<version>].
+
+  `,
+	Run: manifestVerifyProofCmd,
+}
+
 func init() {
+	cmd_data_manifest.Flag.String("symlinks", "", "symlink policy")
+	cmd_data_manifest.Flag.Bool("merkle", false, "use merkle root as ref")
+	cmd_data_manifest.Flag.String("format", "", "manifest format")
 	cmd_data_manifest_add.Flag.Bool("all", false, "add all available files")
 	cmd_data_manifest_rm.Flag.Bool("all", false, "remove all tracked files")
+	cmd_data_manifest_rm.Flag.Bool("missing", false, "remove all missing files")
 	cmd_data_manifest_hash.Flag.Bool("all", false, "hash all tracked files")
+	cmd_data_manifest_hash.Flag.Bool("rehash", false, "rehash all hashed files")
+	cmd_data_manifest_hash.Flag.Bool("unhashed", false, "hash all unhashed files")
 	cmd_data_manifest_check.Flag.Bool("all", false, "check all tracked files")
 }
 
 func manifestCmd(c *commander.Command, args []string) error {
+	// manifest streams are generated without loading them.
+	s, err := OpenManifestStream(ManifestFileName)
+	if err != nil {
+		return err
+	}
+
+	if s != nil {
+		mf := NewManifest("")
+		mf.Merkle, mf.Symlinks, mf.Stream = s.Merkle, s.Symlinks, true
+		if err := setManifestFlags(c, mf); err != nil {
+			return err
+		}
+
+		if mf.Stream {
+			s.Merkle, s.Symlinks = mf.Merkle, mf.Symlinks
+			return s.Generate()
+		}
+	}
+
 	mf := NewDefaultManifest()
+	if err := setManifestFlags(c, mf); err != nil {
+		return err
+	}
+
+	// converting to a stream: write it, and generate the stream.
+	if mf.Stream {
+		if err := mf.WriteFile(); err != nil {
+			return err
+		}
+
+		s, err := OpenManifestStream(ManifestFileName)
+		if err != nil {
+			return err
+		}
+		return s.Generate()
+	}
 	return mf.Generate()
 }
 
+// Sets manifest options from the --symlinks, --merkle, and --format flags.
+func setManifestFlags(c *commander.Command, mf *Manifest) error {
+	if c.Flag.Lookup("merkle").Value.Get().(bool) {
+		mf.Merkle = true
+	}
+
+	switch format := c.Flag.Lookup("format").Value.Get().(string); format {
+	case "":
+	case ManifestFormatYAML:
+		mf.Stream = false
+	case ManifestFormatStream:
+		mf.Stream = true
+	default:
+		return fmt.Errorf("%v: invalid manifest format '%s'. "+
+			"Use one of: yaml, stream.", c.FullName(), format)
+	}
+
+	policy := c.Flag.Lookup("symlinks").Value.Get().(string)
+	if len(policy) == 0 {
+		return nil
+	}
+
+	if !validSymlinkPolicy(policy) {
+		return fmt.Errorf("%v: invalid symlink policy '%s'. "+
+			"Use one of: follow, link, error.", c.FullName(), policy)
+	}
+
+	mf.Symlinks = policy
+	return nil
+}
+
 func manifestCmdPaths(c *commander.Command, args []string) ([]string, error) {
 	mf := NewDefaultManifest()
 	paths := args
@@ -169,7 +333,11 @@ func manifestAddCmd(c *commander.Command, args []string) error {
 	// Use all files available if --all is passed in.
 	all := c.Flag.Lookup("all").Value.Get().(bool)
 	if all {
-		paths = listAllFiles(".")
+		l, err := listAllEntries(".", mf.SymlinkPolicy())
+		if err != nil {
+			return err
+		}
+		paths = l.Files
 	}
 
 	if len(paths) < 1 {
@@ -177,36 +345,82 @@ func manifestAddCmd(c *commander.Command, args []string) error {
 	}
 
 	// add files to manifest file
-	for _, f := range paths {
-		err := mf.Add(f)
+	return mf.AddPaths(paths)
+}
+
+func manifestRmCmd(c *commander.Command, args []string) error {
+	mf := NewDefaultManifest()
+
+	// Use all missing files if --missing is passed in.
+	var paths []string
+	if c.Flag.Lookup("missing").Value.Get().(bool) {
+		paths = mf.MissingPaths()
+		if len(paths) < 1 {
+			pErr("data manifest: no missing files.\n")
+			return nil
+		}
+	} else {
+		var err error
+		paths, err = manifestCmdPaths(c, args)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// remove files from manifest file
+	return mf.RemovePaths(paths)
 }
 
-func manifestRmCmd(c *commander.Command, args []string) error {
+func manifestHashCmd(c *commander.Command, args []string) error {
 	mf := NewDefaultManifest()
 
-	paths, err := manifestCmdPaths(c, args)
-	if err != nil {
-		return err
+	var paths []string
+	switch {
+	// Use all tracked files if --all is passed in.
+	case c.Flag.Lookup("all").Value.Get().(bool):
+		paths = mf.AllPaths()
+
+	// Use all hashed files if --rehash is passed in.
+	case c.Flag.Lookup("rehash").Value.Get().(bool):
+		paths = mf.HashedPaths()
+		if len(paths) < 1 {
+			pErr("data manifest: no files hashed yet.\n")
+			return nil
+		}
+
+	// Use all unhashed files if --unhashed is passed in.
+	case c.Flag.Lookup("unhashed").Value.Get().(bool):
+		paths = mf.UnhashedPaths()
+		if len(paths) < 1 {
+			pErr("data manifest: all files hashed.\n")
+			return nil
+		}
+
+	default:
+		paths = args
 	}
 
-	// remove files from manifest file
-	for _, f := range paths {
-		err := mf.Remove(f)
+	if len(paths) < 1 {
+		return fmt.Errorf("%v: no files specified.", c.FullName())
+	}
+
+	// hash files in manifest file
+	return mf.HashPaths(paths)
+}
+
+func manifestCheckCmd(c *commander.Command, args []string) error {
+	// check all files of large manifests as a stream.
+	if c.Flag.Lookup("all").Value.Get().(bool) {
+		s, err := OpenManifestStream(ManifestFileName)
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
-}
+		if s != nil {
+			return checkManifestStream(s)
+		}
+	}
 
-func manifestHashCmd(c *commander.Command, args []string) error {
 	mf := NewDefaultManifest()
 
 	paths, err := manifestCmdPaths(c, args)
@@ -215,56 +429,132 @@ func manifestHashCmd(c *commander.Command, args []string) error {
 	}
 
 	// hash files in manifest file
-	for _, f := range paths {
-		err := mf.Hash(f)
-		if err != nil {
-			return err
-		}
+	failed, err := mf.CheckPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("data manifest check: %d/%d checksums failed.",
+			failed, len(paths))
 	}
 
 	return nil
 }
 
-func manifestCheckCmd(c *commander.Command, args []string) error {
+func checkManifestStream(s *ManifestStream) error {
+	total, failed, err := s.Check()
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("data manifest check: %d/%d checksums failed.",
+			failed, total)
+	}
+	return nil
+}
+
+func manifestProofCmd(c *commander.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("%v: requires <file> argument.", c.FullName())
+	}
+
 	mf := NewDefaultManifest()
+	if !mf.Merkle {
+		pErr("Warning: manifest ref is not a merkle root (see --merkle).\n")
+	}
 
-	paths, err := manifestCmdPaths(c, args)
+	p, err := mf.MerkleProof(args[0])
 	if err != nil {
 		return err
 	}
 
-	// hash files in manifest file
-	failed := 0
-	for _, f := range paths {
-		pass, err := mf.Check(f)
+	rdr, err := Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(os.Stdout, rdr)
+	return err
+}
+
+func manifestVerifyProofCmd(c *commander.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%v: requires <proof> and <ref> arguments.",
+			c.FullName())
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
 		if err != nil {
-			// return err
+			return err
 		}
+		defer f.Close()
+		r = f
+	}
 
-		if !pass {
-			failed++
+	p := &MerkleProof{}
+	if err := Unmarshal(r, p); err != nil {
+		return err
+	}
+
+	ref := args[1]
+	if !IsHash(ref) {
+		h := NewHandle(ref)
+		if !h.Valid() {
+			return fmt.Errorf("%v: invalid ref: %s", c.FullName(), ref)
+		}
+
+		di, err := NewMainDataIndex()
+		if err != nil {
+			return err
+		}
+
+		ref, err = di.handleRef(h)
+		if err != nil {
+			return err
 		}
 	}
 
-	if failed > 0 {
-		return fmt.Errorf("data manifest check: %d/%d checksums failed.",
-			failed, len(paths))
+	if err := p.Verify(ref); err != nil {
+		return fmt.Errorf("data manifest: proof FAIL - %s", err)
 	}
 
+	pOut("data manifest: proof %.7s %s PASS (ref %.7s)\n", p.Leaf.Hash,
+		p.Leaf.Path, ref)
 	return nil
 }
 
 type Manifest struct {
 	SerializedFile "-"
 	Files          blobPaths ""
+
+	// metadata for files (by path), and empty directories.
+	Meta map[string]*FileMeta "-"
+	Dirs map[string]*FileMeta "-"
+
+	// symlink policy, and symlinks stored as links { path : target }
+	Symlinks string            "-"
+	Links    map[string]string "-"
+
+	// whether the manifest hash is a merkle root (see merkle.go)
+	Merkle bool "-"
+
+	// whether the manifest is stored in stream format (manifest_stream.go)
+	Stream bool "-"
 }
 
 func NewManifest(path string) *Manifest {
 	mf := &Manifest{SerializedFile: SerializedFile{Path: path}}
 
-	// initialize map
+	// initialize maps
 	mf.Files = blobPaths{}
-	mf.SerializedFile.Format = mf.Files
+	mf.Meta = map[string]*FileMeta{}
+	mf.Dirs = map[string]*FileMeta{}
+	mf.Links = map[string]string{}
+	mf.SerializedFile.Format = (*manifestFormat)(mf)
 
 	// attempt to load
 	if len(path) > 0 {
@@ -278,52 +568,131 @@ func NewDefaultManifest() *Manifest {
 }
 
 func NewManifestWithRef(ref string) (*Manifest, error) {
-	f := NewManifest("")
-	err := f.ReadBlob(ref)
+	i, err := NewMainDataIndex()
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+
+	return i.manifestWithRef(ref)
+}
+
+// Returns the manifest ref, checking it matches ref (see verifiedManifest).
+func (i *DataIndex) manifestWithRef(ref string) (*Manifest, error) {
+	return i.verifiedManifest(ref)
 }
 
 func (mf *Manifest) Generate() error {
+
+	// manifest streams are generated without loading them.
+	if mf.Stream {
+		s, err := OpenManifestStream(mf.Path)
+		if err != nil {
+			return err
+		}
+
+		// (converting to a stream)
+		if s == nil {
+			if err := mf.WriteFile(); err != nil {
+				return err
+			}
+
+			if s, err = OpenManifestStream(mf.Path); err != nil {
+				return err
+			}
+		}
+
+		s.Merkle, s.Symlinks = mf.Merkle, mf.Symlinks
+		if err := s.Generate(); err != nil {
+			return err
+		}
+		return mf.ReadFile()
+	}
+
 	pErr("Generating Manifest file...\n")
 
 	// add new files to manifest file
 	// (for now add everything. `data manifest {add,rm}` in future)
-	for _, f := range listAllFiles(".") {
-		err := mf.Add(f)
-		if err != nil {
-			return err
+	mf.Symlinks = mf.SymlinkPolicy()
+	l, err := listAllEntries(mf.root(), mf.Symlinks)
+	if err != nil {
+		return err
+	}
+
+	// files now stored as links (or beneath them) are no longer tracked.
+	linked := []string{}
+	for p, _ := range mf.Files {
+		for d := p; d != "." && d != "/"; d = path.Dir(d) {
+			if _, found := l.Links[d]; found {
+				linked = append(linked, p)
+				break
+			}
 		}
 	}
 
+	err = mf.RemovePaths(linked)
+	if err != nil {
+		return err
+	}
+
+	err = mf.AddPaths(l.Files)
+	if err != nil {
+		return err
+	}
+
+	// record empty directories (all others are implied by files), and links
+	mf.Dirs = l.Dirs
+	mf.Links = l.Links
+
 	// warn about manifest-listed files missing from directory
 	// (basically, missing things. User removes individually, or `rm --missing`)
+	missing := mf.MissingPaths()
+	if len(missing) > 0 {
+		pErr("Warning: %d files in Manifest missing from directory:\n",
+			len(missing))
+		for _, f := range missing {
+			pErr("    %s\n", f)
+		}
+		pErr(ManifestMissingMsg)
+	}
 
 	// Once all files are listed, hash all the files, storing the hashes.
-	for f, h := range mf.Files {
-		if IsHash(h) && h != noHash {
-			continue
-		}
+	// (missing files cannot be hashed, skip them.)
+	isMissing := map[string]bool{}
+	for _, f := range missing {
+		isMissing[f] = true
+	}
 
-		err := mf.Hash(f)
-		if err != nil {
-			return err
+	unhashed := []string{}
+	for _, f := range mf.UnhashedPaths() {
+		if !isMissing[f] {
+			unhashed = append(unhashed, f)
 		}
 	}
 
-	if len(mf.Files) == 0 {
-		err := mf.WriteFile()
-		if err != nil {
-			return nil
-		}
+	err = mf.HashPaths(unhashed)
+	if err != nil {
+		return err
+	}
+
+	// record metadata of files hashed before it was tracked.
+	mf.statPaths()
+
+	err = mf.WriteFile()
+	if err != nil {
+		return err
+	}
 
+	if len(mf.Files) == 0 {
 		pErr("Warning: no files in directory. Manifest is empty.\n")
 	} else {
-		pErr("%d files in Manifest.\n", len(mf.Files))
+		pErr("%d files (%s) in Manifest.\n", len(mf.Files),
+			humanBytes(mf.TotalSize(mf.AllPaths())))
 	}
 
+	n := len(mf.Files) + len(mf.Dirs) + len(mf.Links)
+	if n >= ManifestStreamThreshold {
+		pErr(ManifestStreamHintMsg)
+	}
 	return nil
 
 }
@@ -332,92 +701,210 @@ func (mf *Manifest) Clear() error {
 	for f, _ := range mf.Files {
 		delete(mf.Files, f)
 	}
+	mf.Meta = map[string]*FileMeta{}
+	mf.Dirs = map[string]*FileMeta{}
+	mf.Links = map[string]string{}
 	return mf.WriteFile()
 }
 
 func (mf *Manifest) Add(path string) error {
-	// check, dont override (could have hash value)
-	_, exists := (mf.Files)[path]
-	if exists {
-		return nil
-	}
-
-	(mf.Files)[path] = noHash
+	return mf.AddPaths([]string{path})
+}
 
-	// Write out file (store incrementally)
-	err := mf.WriteFile()
+// Adds paths to the manifest, writing it out once.
+func (mf *Manifest) AddPaths(paths []string) error {
+	paths, err := normalizePaths(paths)
 	if err != nil {
 		return err
 	}
 
-	pErr("data manifest: added %s\n", path)
-	return nil
-}
+	added := 0
+	for _, path := range paths {
+		// check, dont override (could have hash value)
+		_, exists := (mf.Files)[path]
+		if exists {
+			continue
+		}
 
-func (mf *Manifest) Remove(path string) error {
-	// check, dont remove nonexistent path
-	_, exists := (mf.Files)[path]
-	if !exists {
+		(mf.Files)[path] = noHash
+		pErr("data manifest: added %s\n", path)
+		added++
+	}
+
+	if added == 0 {
 		return nil
 	}
 
-	delete(mf.Files, path)
+	return mf.WriteFile()
+}
+
+func (mf *Manifest) Remove(path string) error {
+	return mf.RemovePaths([]string{path})
+}
 
-	// Write out file (store incrementally)
-	err := mf.WriteFile()
+// Removes paths from the manifest, writing it out once.
+func (mf *Manifest) RemovePaths(paths []string) error {
+	paths, err := mf.trackedPaths(paths)
 	if err != nil {
 		return err
 	}
 
-	pErr("data manifest: removed %s\n", path)
-	return nil
+	removed := 0
+	for _, path := range paths {
+		// check, dont remove nonexistent path
+		_, exists := (mf.Files)[path]
+		if !exists {
+			continue
+		}
+
+		delete(mf.Files, path)
+		delete(mf.Meta, path)
+		pErr("data manifest: removed %s\n", path)
+		removed++
+	}
+
+	if removed == 0 {
+		return nil
+	}
+
+	return mf.WriteFile()
 }
 
 func (mf *Manifest) Hash(path string) error {
-	h, err := hashFile(path)
+	return mf.HashPaths([]string{path})
+}
+
+// Hashes paths in parallel, storing the hashes in the manifest. Rather
+// than on every hash, the manifest is written out periodically (see
+// ManifestCheckpointInterval) and once done, so progress is not lost
+// if interrupted. Stops at the first error.
+func (mf *Manifest) HashPaths(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	paths, err := normalizePaths(paths)
 	if err != nil {
 		return err
 	}
 
-	(mf.Files)[path] = h
+	stop := make(chan struct{})
+	results := hashFiles(mf.root(), paths, stop)
+	stats := newHashStats()
+	checkpoint := time.Now()
 
-	// Write out file (store incrementally)
-	err = mf.WriteFile()
-	if err != nil {
-		return err
+	for r := range results {
+		if err != nil {
+			continue // draining after error.
+		}
+
+		if r.Err != nil {
+			err = r.Err
+			close(stop)
+			continue
+		}
+
+		(mf.Files)[r.Path] = r.Hash
+		mf.Meta[r.Path] = NewFileMeta(r.Path, r.Info)
+		stats.Add(r)
+		pErr("data manifest: hashed %.7s %s\n", r.Hash, r.Path)
+
+		if time.Since(checkpoint) > ManifestCheckpointInterval {
+			dErr("data manifest: checkpoint (%s)\n", stats)
+			if err = mf.WriteFile(); err != nil {
+				close(stop)
+			}
+			checkpoint = time.Now()
+		}
 	}
 
-	pErr("data manifest: hashed %.7s %s\n", h, path)
-	return nil
+	// Write out file (even on error, to keep what was hashed)
+	if stats.Files > 0 {
+		if werr := mf.WriteFile(); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	if stats.Files > 1 {
+		pErr("data manifest: hashed %s\n", stats)
+	}
+	return err
 }
 
 func (mf *Manifest) Check(path string) (bool, error) {
-	oldHash, found := (mf.Files)[path]
-	if !found {
+	if _, found := (mf.Files)[path]; !found {
 		return false, fmt.Errorf("data manifest: file not in manifest %s", path)
 	}
 
-	mfmt := "data manifest: check %.7s %s %s"
+	failed, err := mf.CheckPaths([]string{path})
+	return failed == 0, err
+}
 
-	newHash, err := hashFile(path)
+// Checks paths in parallel, verifying their checksums match the manifest.
+// Returns the number of paths that failed.
+func (mf *Manifest) CheckPaths(paths []string) (int, error) {
+	paths, err := normalizePaths(paths)
 	if err != nil {
-		switch err.(type) {
-		case *os.PathError:
-			// non existent files count as not hashing correctly.
-			pErr(mfmt, oldHash, path, "FAIL - not found\n")
-			return false, nil
-		default:
-			return false, err
+		return 0, err
+	}
+
+	failed := 0
+	tracked := blobPaths{}
+	for _, path := range paths {
+		if _, found := (mf.Files)[path]; !found {
+			pErr("data manifest: file not in manifest %s\n", path)
+			failed++
+			continue
 		}
+		tracked[path] = (mf.Files)[path]
 	}
 
-	if newHash != oldHash {
-		pErr(mfmt, oldHash, path, "FAIL\n")
-		return false, nil
+	stats := newHashStats()
+	failed += len(checkFiles(mf.root(), tracked, stats))
+
+	if stats.Files > 1 {
+		pErr("data manifest: checked %s\n", stats)
 	}
+	return failed, nil
+}
 
-	dOut(mfmt, oldHash, path, "PASS\n")
-	return true, nil
+// Checks files of the dataset in directory root in parallel, verifying
+// their checksums match the expected ones { path : hash }. Returns the
+// files that failed { path : hash }.
+func checkFiles(root string, expected blobPaths, stats *hashStats) blobPaths {
+	mfmt := "data manifest: check %.7s %s %s"
+
+	paths := []string{}
+	for path, _ := range expected {
+		paths = append(paths, path)
+	}
+
+	failed := blobPaths{}
+	for r := range hashFiles(root, paths, nil) {
+		oldHash := expected[r.Path]
+
+		if r.Err != nil {
+			// non existent files count as not hashing correctly.
+			if _, ok := r.Err.(*os.PathError); ok {
+				pErr(mfmt, oldHash, r.Path, "FAIL - not found\n")
+			} else {
+				pErr(mfmt, oldHash, r.Path, "FAIL - "+r.Err.Error()+"\n")
+			}
+			failed[r.Path] = oldHash
+			continue
+		}
+
+		stats.Add(r)
+		if r.Hash != oldHash {
+			pErr(mfmt, oldHash, r.Path, "FAIL\n")
+			failed[r.Path] = oldHash
+			continue
+		}
+
+		dOut(mfmt, oldHash, r.Path, "PASS\n")
+	}
+
+	return failed
 }
 
 func (mf *Manifest) PathsForHash(hash string) []string {
@@ -439,10 +926,9 @@ func (mf *Manifest) HashForPath(path string) string {
 }
 
 func (mf *Manifest) HashForPathCaseInsensitive(path string) string {
-	path = strings.ToLower(path)
+	path = foldPath(path)
 	for opath, h := range mf.Files {
-		opath = strings.ToLower(opath)
-		if opath == path {
+		if foldPath(opath) == path {
 			return h
 		}
 	}
@@ -457,6 +943,159 @@ func (mf *Manifest) AllPaths() []string {
 	return l
 }
 
+// Returns all tracked paths already hashed.
+func (mf *Manifest) HashedPaths() []string {
+	l := []string{}
+	for p, h := range mf.Files {
+		if IsHash(h) && h != noHash {
+			l = append(l, p)
+		}
+	}
+	return l
+}
+
+// Returns all tracked paths not yet hashed.
+func (mf *Manifest) UnhashedPaths() []string {
+	l := []string{}
+	for p, h := range mf.Files {
+		if !IsHash(h) || h == noHash {
+			l = append(l, p)
+		}
+	}
+	return l
+}
+
+// Returns all tracked paths missing from the directory.
+func (mf *Manifest) MissingPaths() []string {
+	l := []string{}
+	for p, _ := range mf.Files {
+		lp := localPath(path.Join(mf.root(), p))
+		if _, err := os.Lstat(lp); os.IsNotExist(err) {
+			l = append(l, p)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// Records metadata for tracked files that lack it (e.g. hashed by older
+// versions of data), without rehashing them.
+func (mf *Manifest) statPaths() {
+	for p, _ := range mf.Files {
+		if _, found := mf.Meta[p]; found {
+			continue
+		}
+
+		lp := localPath(path.Join(mf.root(), p))
+		if info, err := os.Stat(lp); err == nil {
+			mf.Meta[p] = NewFileMeta(p, info)
+		}
+	}
+}
+
+// Returns the sum of sizes of paths (as recorded in the manifest).
+func (mf *Manifest) TotalSize(paths []string) int64 {
+	var total int64
+	for _, p := range paths {
+		if m, found := mf.Meta[p]; found {
+			total += m.Size
+		}
+	}
+	return total
+}
+
+// Returns the sizes of blobs { hash : size }, as recorded in the manifest.
+// (Build it once, rather than looking up hashes one by one.)
+func (mf *Manifest) HashSizes() map[string]int64 {
+	sizes := map[string]int64{}
+	for p, h := range mf.Files {
+		if m, found := mf.Meta[p]; found {
+			sizes[h] = m.Size
+		}
+	}
+	return sizes
+}
+
+// Returns the directory of the dataset the Manifest describes (paths are
+// relative to it), or "" for the working directory.
+func (mf *Manifest) root() string {
+	return manifestRoot(mf.Path)
+}
+
+// Returns the directory of the dataset with manifest file p (see root).
+func manifestRoot(p string) string {
+	if !strings.HasSuffix(p, ManifestFileName) {
+		return ""
+	}
+	return strings.TrimSuffix(p, ManifestFileName)
+}
+
+// Restores recorded file permissions, empty directories, and symlinks
+// selected by f (all, if nil), in the dataset directory. Progress is
+// printed to out (see wErr).
+// (File contents are restored from blobs, see Pack.Download)
+func (mf *Manifest) RestoreMeta(f *PathFilter, out io.Writer) error {
+	root := filepath.FromSlash(mf.root())
+	local := func(p string) string {
+		return filepath.Join(root, filepath.FromSlash(p))
+	}
+
+	for p, m := range mf.Meta {
+		if m.Mode == 0 || (!f.Match(p) && p != DatafileName) {
+			continue
+		}
+
+		if err := os.Chmod(local(p), m.Mode); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for d, m := range mf.Dirs {
+		if !f.Match(d) {
+			continue
+		}
+
+		mode := m.Mode
+		if mode == 0 {
+			mode = 0777
+		}
+
+		if err := os.MkdirAll(local(d), mode); err != nil {
+			return err
+		}
+	}
+
+	for p, target := range mf.Links {
+		if !f.Match(p) {
+			continue
+		}
+
+		if linkEscapes(p, target, mf.Links) {
+			return fmt.Errorf("symlink %s points outside the dataset (%s).",
+				p, target)
+		}
+
+		lp := local(p)
+		if t, err := os.Readlink(lp); err == nil && t == target {
+			continue // already there.
+		}
+
+		if err := os.MkdirAll(filepath.Dir(lp), 0777); err != nil {
+			return err
+		}
+
+		if err := os.Remove(lp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		wErr(out, "link %s -> %s\n", p, target)
+		if err := os.Symlink(target, lp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (mf *Manifest) AllHashes() []string {
 	l := []string{}
 	for _, h := range mf.Files {
@@ -481,50 +1120,295 @@ func (mf *Manifest) Complete() bool {
 	return true
 }
 
-func listAllFiles(path string) []string {
+// Symlink policies, for generating manifests:
+const (
+	SymlinksFollow = "follow" // store the contents of the link target
+	SymlinksLink   = "link"   // store the link itself (its target path)
+	SymlinksError  = "error"  // refuse to generate manifest
+)
+
+func validSymlinkPolicy(policy string) bool {
+	switch policy {
+	case SymlinksFollow, SymlinksLink, SymlinksError:
+		return true
+	}
+	return false
+}
+
+// Returns the manifest's symlink policy (follow, by default).
+func (mf *Manifest) SymlinkPolicy() string {
+	if len(mf.Symlinks) == 0 {
+		return SymlinksFollow
+	}
+	return mf.Symlinks
+}
 
-	files := []string{}
-	walkFn := func(path string, info os.FileInfo, err error) error {
+// Files, symlinks, and empty directories (with no files beneath them)
+// found in a directory tree.
+type dirListing struct {
+	Files []string
+	Dirs  map[string]*FileMeta
+	Links map[string]string
+}
 
-		if info.IsDir() {
+// Lists all entries under root ("" for the working directory), handling
+// symlinks according to policy.
+func listAllEntries(root string, symlinks string) (*dirListing, error) {
+	l := &dirListing{
+		Files: []string{},
+		Dirs:  map[string]*FileMeta{},
+		Links: map[string]string{},
+	}
+
+	err := walkEntries(root, symlinks, func(r *ManifestRecord) error {
+		switch r.Kind {
+		case "file":
+			l.Files = append(l.Files, r.Path)
+		case "dir":
+			l.Dirs[r.Path] = r.FileMeta()
+		case "link":
+			l.Links[r.Path] = r.Target
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
 
-			// entirely skip hidden dirs
-			if len(info.Name()) > 1 && strings.HasPrefix(info.Name(), ".") {
-				dOut("data manifest: skipping %s/\n", info.Name())
-				return filepath.SkipDir
+// Walks the tree under root, calling fn with its files (unhashed), links,
+// and empty directories, in manifest order (see recordLess). Only the
+// entries of the directories being walked are held in memory, so large
+// trees can be merged into manifest streams as they are walked.
+func walkEntries(root string, symlinks string,
+	fn func(*ManifestRecord) error) error {
+
+	if len(root) == 0 {
+		root = "."
+	}
+
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	w := &entryWalker{symlinks: symlinks, parents: map[string]bool{}, fn: fn}
+	_, err = w.walk(root, "", real)
+	return err
+}
+
+type entryWalker struct {
+	symlinks string
+	parents  map[string]bool // real paths of dirs being walked, to avoid loops.
+	fn       func(*ManifestRecord) error
+}
+
+// A directory entry, with symlinks followed (unless stored as links).
+type walkEntry struct {
+	local  string // local path
+	path   string // manifest path
+	key    string // sort key
+	info   os.FileInfo
+	real   string // real path of directories
+	link   bool
+	target string
+}
+
+type walkEntryList []*walkEntry
+
+func (l walkEntryList) Len() int           { return len(l) }
+func (l walkEntryList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l walkEntryList) Less(i, j int) bool { return l[i].key < l[j].key }
+
+// Walks directory dir (manifest path p). Returns the number of entries
+// found beneath it.
+func (w *entryWalker) walk(dir string, p string, real string) (int, error) {
+	w.parents[real] = true
+	defer delete(w.parents, real)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		dErr("data manifest: %s\n", err)
+		return 0, nil
+	}
+
+	entries := walkEntryList{}
+	local := map[string]string{} // { manifest path : local path }
+	for _, info := range infos {
+		e, err := w.entry(dir, p, info)
+		if err != nil {
+			return 0, err
+		}
+		if e == nil {
+			continue
+		}
+
+		// names that differ only by unicode normalization cannot both
+		// be stored.
+		if other, found := local[e.path]; found {
+			return 0, fmt.Errorf(NormalizationCollisionMsg, other, e.local,
+				e.path)
+		}
+		local[e.path] = e.local
+		entries = append(entries, e)
+	}
+
+	// entries beneath a directory sort after its path and a "/", so
+	// directories sort by that, unless empty (stored as themselves).
+	for _, e := range entries {
+		e.key = e.path
+		if !e.isDir() {
+			continue
+		}
+
+		e.key = e.path + "/"
+		if sortsBetween(entries, e.path, e.key) {
+			empty, err := w.empty(e)
+			if err != nil {
+				return 0, err
+			}
+			if empty {
+				e.key = e.path
+			}
+		}
+	}
+	sort.Sort(entries)
+
+	n := 0
+	for _, e := range entries {
+		if e.isDir() {
+			c, err := w.walk(e.local, e.path, e.real)
+			if err != nil {
+				return n, err
 			}
 
-			// skip datasets/
-			if path == DatasetDir {
-				dOut("data manifest: skipping %s/\n", info.Name())
-				return filepath.SkipDir
+			if c > 0 {
+				n += c
+				continue
 			}
+		}
 
-			// dont store dirs
-			return nil
+		if err := w.fn(e.record()); err != nil {
+			return n, err
 		}
+		n++
+	}
+	return n, nil
+}
 
-		// skip manifest file
-		if path == ManifestFileName {
-			dOut("data manifest: skipping %s\n", info.Name())
-			return nil
+// Returns the entry for info in dir (nil if skipped).
+func (w *entryWalker) entry(dir string, p string, info os.FileInfo) (
+	*walkEntry, error) {
+
+	e := &walkEntry{local: filepath.Join(dir, info.Name()), info: info}
+
+	// entirely skip hidden files and dirs
+	if strings.HasPrefix(info.Name(), ".") {
+		dOut("data manifest: skipping %s\n", e.local)
+		return nil, nil
+	}
+
+	// skip datasets/
+	if len(p) == 0 && info.Name() == DatasetDir {
+		dOut("data manifest: skipping %s/\n", e.local)
+		return nil, nil
+	}
+
+	name, err := NormalizePath(info.Name())
+	if err != nil {
+		return nil, err
+	}
+	e.path = path.Join(p, name)
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch w.symlinks {
+		case SymlinksError:
+			return nil, fmt.Errorf(SymlinkErrorMsg, e.local)
+
+		case SymlinksLink:
+			target, err := os.Readlink(e.local)
+			if err != nil {
+				return nil, err
+			}
+
+			if filepath.IsAbs(target) {
+				pErr("Warning: symlink %s has absolute target %s\n", e.local,
+					target)
+			}
+
+			e.link, e.target = true, filepath.ToSlash(target)
+			return e, nil
 		}
 
-		// skip hidden files
-		if strings.HasPrefix(info.Name(), ".") {
-			dOut("data manifest: skipping %s\n", info.Name())
-			return nil
+		// follow
+		e.info, err = os.Stat(e.local)
+		if err != nil {
+			pErr("Warning: skipping broken symlink %s\n", e.local)
+			return nil, nil
 		}
+	}
 
-		files = append(files, path)
-		return nil
+	if !e.info.IsDir() {
+		return e, nil
 	}
 
-	filepath.Walk(path, walkFn)
-	return files
+	// dont follow symlink loops
+	e.real, err = filepath.EvalSymlinks(e.local)
+	if err != nil {
+		return nil, err
+	}
+	if w.parents[e.real] {
+		pErr("Warning: skipping symlink loop %s\n", e.local)
+		return nil, nil
+	}
+	return e, nil
+}
+
+var errEntryFound = fmt.Errorf("entry found")
+
+// Returns whether directory e has no entries beneath it.
+func (w *entryWalker) empty(e *walkEntry) (bool, error) {
+	found := &entryWalker{symlinks: w.symlinks, parents: w.parents,
+		fn: func(*ManifestRecord) error { return errEntryFound }}
+
+	_, err := found.walk(e.local, e.path, e.real)
+	if err == errEntryFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Whether any entry path sorts strictly between a and b.
+func sortsBetween(entries walkEntryList, a, b string) bool {
+	for _, e := range entries {
+		if e.path > a && e.path < b {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *walkEntry) isDir() bool {
+	return !e.link && e.info.IsDir()
+}
+
+func (e *walkEntry) record() *ManifestRecord {
+	switch {
+	case e.link:
+		return &ManifestRecord{Kind: "link", Path: e.path, Target: e.target}
+	case e.info.IsDir():
+		m := newManifestEntry("", NewFileMeta(e.local, e.info))
+		return &ManifestRecord{Kind: "dir", Path: e.path, Mode: m.Mode}
+	}
+	return &ManifestRecord{Kind: "file", Path: e.path, Hash: noHash}
 }
 
 func (mf *Manifest) ManifestHash() (string, error) {
+	if mf.Merkle {
+		return mf.MerkleRoot(), nil
+	}
+
 	buf, err := mf.Marshal()
 	if err != nil {
 		return "", err
@@ -533,3 +1417,27 @@ func (mf *Manifest) ManifestHash() (string, error) {
 	r := bytes.NewReader(buf)
 	return readerHash(r)
 }
+
+const ManifestMissingMsg = `These files are still tracked, with their last known checksums.
+If they were deleted on purpose, stop tracking them with:
+
+    data manifest rm --missing
+
+`
+
+const ManifestStreamHintMsg = `This manifest is large. Store it as a stream, to generate and check
+it without loading it into memory:
+
+    data manifest --format stream
+
+(This changes the manifest's hash, the dataset ref, unless --merkle.)
+`
+
+const NormalizationCollisionMsg = `Paths collide: %q and %q
+Both are stored in the manifest as %q (unicode NFC normalized).
+Please rename one of them.`
+
+const SymlinkErrorMsg = `Symlink found: %s
+The manifest symlink policy is 'error'. To include symlinks, either:
+  - Store the link targets' contents, with '--symlinks follow'.
+  - Store the links themselves, with '--symlinks link'.`
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"fmt"
+	"github.com/jbenet/commander"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var cmd_data_outdated = &commander.Command{
+	UsageLine: "outdated",
+	Short:     "Show installed datasets with newer versions.",
+	Long: `data outdated - Show installed datasets with newer versions.
+
+    Compares the version of each dataset installed (in the dataset
+    working directory) against the versions published in the index, and
+    lists those that are not up to date:
+
+        Dataset                        Current    Wanted     Latest
+        jbenet/foo                     1.0        1.3        2.0
+
+    Current is the version installed. Wanted is the version the Datafile
+    dependencies resolve to now (the latest satisfying all version
+    ranges), which 'data update' installs. Latest is the latest version
+    published. Datasets that are not Datafile dependencies (nor theirs)
+    have no wanted version ("-"). Versions are compared as versions, so
+    1.0 is 1.0.0. Datasets not in the index (e.g. installed from urls)
+    are skipped.
+
+  `,
+	Run: outdatedCmd,
+}
+
+func outdatedCmd(c *commander.Command, args []string) error {
+	di, err := NewMainDataIndex()
+	if err != nil {
+		return err
+	}
+
+	df, _ := NewDefaultDatafile()
+	outdated, err := OutdatedDatasets(di, df)
+	if err != nil {
+		return err
+	}
+
+	if len(outdated) == 0 {
+		pOut("All installed datasets are up to date.\n")
+		return nil
+	}
+
+	pOut("%-30s %-10s %-10s %-10s\n", "Dataset", "Current", "Wanted", "Latest")
+	for _, o := range outdated {
+		pOut("%-30s %-10s %-10s %-10s\n", o.Path, orDash(o.Current),
+			orDash(o.Wanted), orDash(o.Latest))
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
+
+// An installed dataset version, and the versions it could be updated to.
+type OutdatedDataset struct {
+	Path    string // <author>/<name>
+	Current string // installed version
+	Wanted  string // version Datafile dependencies resolve to ("" if none)
+	Latest  string // latest published version
+}
+
+// Returns the installed datasets not at their wanted or latest versions,
+// sorted by path. Wanted versions are resolved from df's dependencies.
+// Datasets not in index di (e.g. installed from urls) are skipped.
+func OutdatedDatasets(di *DataIndex, df *Datafile) ([]*OutdatedDataset,
+	error) {
+
+	installed, err := installedDatasets(DatasetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]string{}
+	if _, handles := splitUrls(df.Dependencies); len(handles) > 0 {
+		deps, err := parseDependencies(handles, "")
+		if err != nil {
+			return nil, err
+		}
+
+		plan, err := ResolveDependencies(di, deps, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range plan.Datasets {
+			wanted[s.Path] = s.Version
+		}
+	}
+
+	latest := map[string]string{}
+	outdated := []*OutdatedDataset{}
+	for _, h := range installed {
+		p := h.Path()
+		if _, found := latest[p]; !found {
+			ri := di.RefIndex(p)
+			err := ri.FetchRefs(false)
+			switch {
+			case err == nil:
+				latest[p] = ri.Refs.ResolveVersion(RefLatest)
+			case strings.Contains(err.Error(), "404 page not found"):
+				di.pErr("Warning: %s not found in %s. Skipping it.\n", p,
+					di.Name)
+				latest[p] = ""
+			default:
+				return nil, fmt.Errorf("Error finding versions of %v. %s", p, err)
+			}
+		}
+
+		// (not in the index, e.g. installed from a url.)
+		if len(latest[p]) == 0 {
+			continue
+		}
+
+		o := &OutdatedDataset{
+			Path:    p,
+			Current: h.Version,
+			Wanted:  wanted[p],
+			Latest:  latest[p],
+		}
+
+		if (len(o.Wanted) > 0 && !versionEqual(o.Current, o.Wanted)) ||
+			VersionLess(o.Current, o.Latest) {
+			outdated = append(outdated, o)
+		}
+	}
+	return outdated, nil
+}
+
+// Returns the datasets installed in dir (<author>/<name>[@<version>]
+// directories), sorted.
+func installedDatasets(dir string) ([]*Handle, error) {
+	authors, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []*Handle{}, nil // none installed.
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data: error reading dataset directory "+
+			"\"%s\". %s", dir, err)
+	}
+
+	datasets := []string{}
+	for _, a := range authors {
+		// skip hidden files (e.g. staged installs)
+		if !a.IsDir() || a.Name()[0] == '.' {
+			continue
+		}
+
+		ds, err := ioutil.ReadDir(path.Join(dir, a.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, d := range ds {
+			if d.IsDir() && d.Name()[0] != '.' {
+				datasets = append(datasets, path.Join(a.Name(), d.Name()))
+			}
+		}
+	}
+	sort.Strings(datasets)
+
+	handles := []*Handle{}
+	for _, d := range datasets {
+		handles = append(handles, NewHandle(d))
+	}
+	return handles, nil
+}
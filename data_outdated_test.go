@@ -0,0 +1,73 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// An index serving the refs of datasets { path : versions, oldest first }.
+func testRefsIndex(t *testing.T, published map[string]string) *httptest.Server {
+	refs := map[string]*DatasetRefs{}
+	for path, versions := range published {
+		r := &DatasetRefs{Published: map[string]string{},
+			Versions: map[string]string{}}
+		for i, v := range strings.Fields(versions) {
+			ref := testRef(path, v)
+			r.Versions[v] = ref
+			r.Published[ref] = fmt.Sprintf("2014-01-%02dT00:00:00Z", i+1)
+		}
+		refs["/api/v1/"+path+"/refs/"] = r
+	}
+
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ds, found := refs[r.URL.Path]
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+
+			buf, err := Marshal(ds)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(w, buf)
+		}))
+}
+
+func TestOutdatedDatasets(t *testing.T) {
+	defer testDatasetDir(t, "datasets/a/b@1.0/", "datasets/c/d@2.0/",
+		"datasets/e/f@1.2/", "datasets/x/y@1.0/")()
+
+	srv := testRefsIndex(t, map[string]string{
+		"a/b": "0.9 1.0.0",
+		"c/d": "1.0 1.5",
+		"e/f": "1.2 1.10",
+	})
+	defer srv.Close()
+
+	out := &bytes.Buffer{}
+	di := &DataIndex{Name: "test", Http: newHttpClientWithUrl(srv.URL, "", ""),
+		out: out}
+
+	outdated, err := OutdatedDatasets(di, &Datafile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a/b is at 1.0.0, c/d is newer than the latest, x/y is not published.
+	if len(outdated) != 1 || outdated[0].Path != "e/f" ||
+		outdated[0].Latest != "1.10" {
+		t.Errorf("outdated: %v", outdated)
+	}
+
+	if !strings.Contains(out.String(), "x/y not found") {
+		t.Errorf("no warning for x/y: %q", out.String())
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
-	"os"
 	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -21,7 +21,11 @@ var cmd_data_pack = &commander.Command{
       pack upload     Upload package to remote storage.
       pack download   Download package from remote storage.
       pack publish    Publish package to dataset index.
+      pack sign       Sign package manifest.
       pack checksum   Verify all file checksums match.
+      pack repair     Restore corrupt or deleted files from blobs.
+      pack archive    Write package to a tarball.
+      pack unarchive  Extract and verify a package tarball.
 
 
   What is a data package?
@@ -66,10 +70,29 @@ var cmd_data_pack = &commander.Command{
     The package should already be uploaded (to the storage service).
     Publishing requires index credentials (see 'data user').
 
+  data pack sign
+
+    Packages can be signed, so others can verify they were published by
+    the owner, and not tampered with. Running 'data pack sign' signs the
+    manifest reference (hash) with the user's key, and uploads the
+    signature. 'data get' verifies signatures.
+
   data pack checksum
 
     Packages can be verified entirely by calling the 'data pack checksum'
     command. It re-hashes every file and ensures the checksums match.
+
+  data pack repair
+
+    Files that fail their checksums can be restored from their blobs
+    (local copies, or the storage service) with 'data pack repair'.
+
+  data pack archive
+
+    Packages can be written to a single tarball (.tar.gz), with the
+    Datafile, Manifest, and all files. Archives are reproducible: the
+    same package always produces the same archive. 'data pack unarchive'
+    extracts an archive, and verifies every file against its Manifest.
   `,
 
 	Subcommands: []*commander.Command{
@@ -78,19 +101,41 @@ var cmd_data_pack = &commander.Command{
 		cmd_data_pack_upload,
 		cmd_data_pack_download,
 		cmd_data_pack_publish,
+		cmd_data_pack_sign,
 		cmd_data_pack_check,
+		cmd_data_pack_repair,
+		cmd_data_pack_archive,
+		cmd_data_pack_unarchive,
 	},
 }
 
 var cmd_data_pack_make = &commander.Command{
 	UsageLine: "make",
 	Short:     "Create or update package description.",
-	Long: `data pack upload - Upload package contents to remote storage.
+	Long: `data pack make - Create or update package description.
 
     Makes the package's description files:
     - Datafile, containing dataset description and metadata (prompts)
     - Manifest, containing dataset file paths and checksums (generated)
 
+    Symlinks are handled according to --symlinks (follow, link, error).
+    With --merkle, the dataset ref is the manifest's Merkle root. With
+    --format (yaml, stream), the manifest format is changed.
+    See 'data manifest'.
+
+    Datafile fields can also be set without prompting (e.g. for CI), by
+    flag (--<field>), environment variable (DATA_<FIELD>), or template
+    Datafile (--template <file>, or DATA_TEMPLATE). Flags take precedence
+    over environment variables, and those over the template. Fields:
+
+        dataset, owner, name, version, tagline, description, license,
+        website, repository, authors, contributors, sources, mirrors,
+        dependencies   (lists are comma-separated)
+
+    With --yes (or --non-interactive, or DATA_NON_INTERACTIVE=1), nothing
+    is prompted for. If required fields are missing, make fails listing
+    them (exit code 2).
+
     See 'data pack'.
   `,
 	Run:  packMakeCmd,
@@ -121,9 +166,13 @@ var cmd_data_pack_upload = &commander.Command{
     file, avoiding redundant uploads, saving bandwidth, and leveraging
     the data uploaded along with other datasets.
 
+    With --dry-run, shows which blobs would be uploaded, skipped (already
+    in storage), or are missing locally, with sizes, and uploads nothing.
+
     See 'data pack'.
   `,
-	Run: packUploadCmd,
+	Run:  packUploadCmd,
+	Flag: *flag.NewFlagSet("data-pack-upload", flag.ExitOnError),
 }
 
 var cmd_data_pack_download = &commander.Command{
@@ -137,9 +186,21 @@ var cmd_data_pack_download = &commander.Command{
     bandwidth and speed, as well as verify the correctness of files with
     their checksum, preventing corruption.
 
+    Files already present (with the right checksum) are not downloaded
+    again. File hashes are cached (.data/Hashcache), so unchanged files
+    are not rehashed either.
+
+    With --include and --exclude (comma-separated path globs), only the
+    matching files are downloaded (see 'data get').
+
+    With --dry-run, shows which blobs would be downloaded, skipped (already
+    present, with the right checksum), or are missing from storage, with
+    sizes, and downloads nothing.
+
     See 'data pack'.
   `,
-	Run: packDownloadCmd,
+	Run:  packDownloadCmd,
+	Flag: *flag.NewFlagSet("data-pack-download", flag.ExitOnError),
 }
 
 var cmd_data_pack_publish = &commander.Command{
@@ -151,6 +212,9 @@ var cmd_data_pack_publish = &commander.Command{
     Package manifest (and all blobs) should be already uploaded. If any
     blob has not been uploaded, publish will exit with an error.
 
+    With --dry-run, shows which blobs must be uploaded first, and whether
+    the version would be published, and publishes nothing.
+
     Note: publishing requires data index credentials; see 'data user'.
 
     See 'data pack'.
@@ -159,6 +223,26 @@ var cmd_data_pack_publish = &commander.Command{
 	Flag: *flag.NewFlagSet("data-pack-publish", flag.ExitOnError),
 }
 
+var cmd_data_pack_sign = &commander.Command{
+	UsageLine: "sign",
+	Short:     "Sign package manifest.",
+	Long: `data pack sign - Sign package manifest.
+
+    Signs the package's manifest reference (hash) with the user's ed25519
+    signing key, and uploads the signature to the remote storage service,
+    alongside the manifest. If the user has no signing key yet, one is
+    generated and stored in the config file (signing.private).
+
+    When installing with 'data get', signatures are verified against the
+    keys trusted for each author. The first key seen for an author is
+    trusted, and stored in the config file (trusted.<author>). From then
+    on, 'data get' refuses datasets by that author not signed with it.
+
+    See 'data pack'.
+  `,
+	Run: packSignCmd,
+}
+
 var cmd_data_pack_check = &commander.Command{
 	UsageLine: "check",
 	Short:     "Verify all file checksums match.",
@@ -166,17 +250,91 @@ var cmd_data_pack_check = &commander.Command{
 
     Verifies all package's file (blob) checksums match hashes stored in
     the Manifest. This is the way to check package-wide integrity. If any
-    checksums FAIL, it is suggested that the files be re-downloaded (using
-    'data pack download' or 'data blob get <hash>').
+    checksums FAIL, it is suggested that the files be restored (using
+    'data pack repair').
 
     See 'data pack'.
   `,
 	Run: packCheckCmd,
 }
 
+var cmd_data_pack_repair = &commander.Command{
+	UsageLine: "repair",
+	Short:     "Restore corrupt or deleted files from blobs.",
+	Long: `data pack repair - Restore corrupt or deleted files from blobs.
+
+    Verifies all package's file checksums (see 'data pack check'), and
+    restores each file that fails (corrupted, modified, or deleted) from
+    its blob: from a local copy (another file with the same contents), or
+    from the remote storage service (datadex). Restored files are verified
+    against the Manifest as they are written.
+
+    With --dry-run, shows which files would be restored, and from where,
+    and restores nothing.
+
+    See 'data pack'.
+  `,
+	Run:  packRepairCmd,
+	Flag: *flag.NewFlagSet("data-pack-repair", flag.ExitOnError),
+}
+
+var cmd_data_pack_archive = &commander.Command{
+	UsageLine: "archive [<file>]",
+	Short:     "Write package to a tarball.",
+	Long: `data pack archive - Write package to a tarball.
+
+    Writes the package (Datafile, Manifest, and all files in the Manifest)
+    to a gzipped tarball, under a <name>-<version>/ directory. Files are
+    verified against the Manifest as they are archived.
+
+    Archives are reproducible: entries are sorted (Manifest first), and
+    have fixed modification times and owners, so the same package always
+    produces the same archive.
+
+    See 'data pack'.
+
+Arguments:
+
+    <file>   archive filename (default: <name>-<version>.tar.gz).
+
+  `,
+	Run: packArchiveCmd,
+}
+
+var cmd_data_pack_unarchive = &commander.Command{
+	UsageLine: "unarchive <file> [<dir>]",
+	Short:     "Extract and verify a package tarball.",
+	Long: `data pack unarchive - Extract and verify a package tarball.
+
+    Extracts a package archive (see 'data pack archive') into <dir>, and
+    verifies every file against the archive's Manifest. If any file is
+    missing or does not match its checksum, <dir> is removed. Entries
+    outside <dir> (absolute paths, '..', or beneath symlinks) are refused.
+
+    See 'data pack'.
+
+Arguments:
+
+    <file>   archive filename.
+    <dir>    directory to extract to (default: <file> without .tar.gz).
+
+  `,
+	Run: packUnarchiveCmd,
+}
+
 func init() {
 	cmd_data_pack_make.Flag.Bool("clean", false, "make pack from scratch")
+	cmd_data_pack_make.Flag.String("symlinks", "", "manifest symlink policy")
+	cmd_data_pack_make.Flag.Bool("merkle", false, "use merkle root as ref")
+	cmd_data_pack_make.Flag.String("format", "", "manifest format")
+	addDatafileFlags(&cmd_data_pack_make.Flag)
 	cmd_data_pack_publish.Flag.Bool("force", false, "overwrite published version")
+	cmd_data_pack_upload.Flag.Bool("dry-run", false, "show upload plan only")
+	cmd_data_pack_download.Flag.Bool("dry-run", false, "show download plan only")
+	cmd_data_pack_download.Flag.String("include", "", "only download matching paths")
+	cmd_data_pack_download.Flag.String("exclude", "", "do not download matching paths")
+	cmd_data_pack_publish.Flag.Bool("dry-run", false, "show publish plan only")
+	cmd_data_pack_repair.Flag.Bool("dry-run", false, "show repair plan only")
 }
 
 func packMakeCmd(c *commander.Command, args []string) error {
@@ -185,7 +343,17 @@ func packMakeCmd(c *commander.Command, args []string) error {
 		return err
 	}
 
-	return p.Make(c.Flag.Lookup("clean").Value.Get().(bool))
+	if err := setManifestFlags(c, p.manifest); err != nil {
+		return err
+	}
+
+	p.datafileDefaults()
+	if err := applyDatafileFlags(c, p.datafile); err != nil {
+		return err
+	}
+
+	clean := c.Flag.Lookup("clean").Value.Get().(bool)
+	return p.Make(clean, !nonInteractive(c))
 }
 
 func packManifestCmd(c *commander.Command, args []string) error {
@@ -208,6 +376,10 @@ func packUploadCmd(c *commander.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+
+	if c.Flag.Lookup("dry-run").Value.Get().(bool) {
+		return p.UploadDryRun()
+	}
 	return p.Upload()
 }
 
@@ -216,7 +388,16 @@ func packDownloadCmd(c *commander.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	return p.Download()
+
+	f, err := pathFilterFlags(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Flag.Lookup("dry-run").Value.Get().(bool) {
+		return p.DownloadDryRun(f)
+	}
+	return p.Download(f)
 }
 
 func packPublishCmd(c *commander.Command, args []string) error {
@@ -226,13 +407,18 @@ func packPublishCmd(c *commander.Command, args []string) error {
 	}
 
 	force := c.Flag.Lookup("force").Value.Get().(bool)
+	if c.Flag.Lookup("dry-run").Value.Get().(bool) {
+		return p.PublishDryRun(force)
+	}
+
 	err = p.Publish(force)
 	if err != nil {
 		if strings.Contains(err.Error(), "forbidden") {
 			u := configUser()
 			d := p.datafile.Handle().Path()
 			o := p.datafile.Handle().Author
-			return fmt.Errorf(PublishingForbiddenMsg, u, d, o, err.Error())
+			return ExitErrorf(ExitAuth, PublishingForbiddenMsg, u, d, o,
+				err.Error())
 		}
 		return err
 	}
@@ -240,6 +426,39 @@ func packPublishCmd(c *commander.Command, args []string) error {
 	return nil
 }
 
+func packSignCmd(c *commander.Command, args []string) error {
+	p, err := NewPack()
+	if err != nil {
+		return err
+	}
+	return p.Sign()
+}
+
+func packArchiveCmd(c *commander.Command, args []string) error {
+	p, err := NewPack()
+	if err != nil {
+		return err
+	}
+
+	filename := ""
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	return p.Archive(filename)
+}
+
+func packUnarchiveCmd(c *commander.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("%v: requires <file> argument.", c.FullName())
+	}
+
+	dst := ""
+	if len(args) > 1 {
+		dst = args[1]
+	}
+	return UnarchivePack(args[0], dst)
+}
+
 func packCheckCmd(c *commander.Command, args []string) error {
 	p, err := NewPack()
 	if err != nil {
@@ -250,47 +469,59 @@ func packCheckCmd(c *commander.Command, args []string) error {
 		pErr("Warning: manifest incomplete. Checksums may be incorrect.")
 	}
 
-	failures := 0
-
-	for _, file := range p.manifest.AllPaths() {
-		pass, err := p.manifest.Check(file)
-		if err != nil {
-			return err
-		}
-
-		if !pass {
-			failures++
-		}
+	count, failed, err := p.checkFailures()
+	if err != nil {
+		return err
 	}
 
-	count := len(p.manifest.Files)
-	if failures > 0 {
-		return fmt.Errorf("data pack: %v/%v checksums failed!", failures, count)
+	if len(failed) > 0 {
+		return fmt.Errorf("data pack: %v/%v checksums failed! "+
+			"Run 'data pack repair' to restore them.", len(failed), count)
 	}
 
 	pOut("data pack: %v checksums pass\n", count)
 	return nil
 }
 
+func packRepairCmd(c *commander.Command, args []string) error {
+	p, err := NewPack()
+	if err != nil {
+		return err
+	}
+
+	return p.Repair(c.Flag.Lookup("dry-run").Value.Get().(bool))
+}
+
 type Pack struct {
 	manifest *Manifest
 	datafile *Datafile
 	index    *DataIndex
+	root     string // directory ("" for the working directory)
 }
 
 func NewPack() (p *Pack, err error) {
-	p = &Pack{}
-	p.manifest = NewDefaultManifest()
-
-	p.datafile, _ = NewDefaultDatafile()
-	// ignore error loading datafile
+	return NewPackAt("")
+}
 
-	p.index, err = NewMainDataIndex()
+// Returns the pack in directory root ("" for the working directory).
+func NewPackAt(root string) (p *Pack, err error) {
+	di, err := NewMainDataIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	return p, nil
+	return newPackWithIndex(root, di), nil
+}
+
+// Returns the pack in directory root, using index di.
+func newPackWithIndex(root string, di *DataIndex) *Pack {
+	p := &Pack{root: root, index: di}
+	p.manifest = NewManifest(path.Join(root, ManifestFileName))
+
+	p.datafile, _ = NewDatafile(path.Join(root, DatafileName))
+	// ignore error loading datafile
+
+	return p
 }
 
 func (p *Pack) BlobPaths() (blobPaths, error) {
@@ -300,11 +531,13 @@ func (p *Pack) BlobPaths() (blobPaths, error) {
 	}
 
 	blobs := validBlobHashes(p.manifest.Files)
-	blobs[p.manifest.Path] = mfh
+	blobs[ManifestFileName] = mfh
 	return blobs, nil
 }
 
-func (p *Pack) Make(clean bool) error {
+// Makes the pack: fills out the Datafile (prompting for fields, if
+// interactive), and generates the Manifest.
+func (p *Pack) Make(clean bool, interactive bool) error {
 	if clean {
 		err := p.manifest.Clear()
 		if err != nil {
@@ -312,16 +545,15 @@ func (p *Pack) Make(clean bool) error {
 		}
 	}
 
-	// fill out datafile defaults.
-	if len(p.datafile.Dataset) == 0 {
-		cwd, _ := os.Getwd()
-		cwd = path.Base(cwd)
-		name := identString(cwd)
-		p.datafile.Dataset = configUser() + "/" + name + "@1.0"
-	}
+	p.datafileDefaults()
 
 	// ensure the dataset has required information
-	err := fillOutDatafile(p.datafile)
+	var err error
+	if interactive {
+		err = fillOutDatafile(p.datafile)
+	} else {
+		err = checkDatafileInputs(p.datafile)
+	}
 	if err != nil {
 		return err
 	}
@@ -339,7 +571,8 @@ func (p *Pack) Make(clean bool) error {
 		return err
 	}
 
-	return nil
+	// ensure the dataset installs correctly on other filesystems.
+	return p.manifest.CheckPortable()
 }
 
 // Check the blobstore to check which blobs in pack have not been uploaded.
@@ -376,37 +609,111 @@ func (p *Pack) Upload() error {
 		return err
 	}
 
-	return putBlobs(blobs)
+	return p.index.putBlobsAt(p.root, blobs)
 }
 
 // Downloads pack from index.
-func (p *Pack) Download() error {
+// Downloads the files selected by f (all, if nil). The Manifest is kept
+// whole, and the Datafile always downloaded.
+func (p *Pack) Download(f *PathFilter) error {
 	if !p.manifest.Complete() {
 		return fmt.Errorf(`Manifest incomplete. Get new manifest copy.`)
 	}
 
-	blobs, err := p.BlobPaths()
+	// never write outside the dataset directory.
+	if err := p.manifest.ValidatePaths(); err != nil {
+		return err
+	}
+
+	blobs, err := p.selectedBlobs(f)
 	if err != nil {
 		return err
 	}
 
-	return getBlobs(blobs)
+	// skip files already present, with the right checksum.
+	cache := openHashCache(p.root)
+	present := upToDate(blobs, cache)
+	fetch := blobPaths{}
+	for path, hash := range blobs {
+		if _, found := present[path]; !found {
+			fetch[path] = hash
+		}
+	}
+
+	// sizes (if recorded), for planning and progress.
+	sizes := map[string]int64{}
+	for path, hash := range fetch {
+		if m, found := p.manifest.Meta[path]; found {
+			sizes[hash] = m.Size
+		}
+	}
+
+	if len(sizes) > 0 {
+		total := p.manifest.TotalSize(sortedPaths(fetch))
+		p.index.pErr("Downloading %d files (%s).\n", len(fetch),
+			humanBytes(total))
+	}
+
+	err = p.index.getBlobsAt(p.root, fetch, sizes)
+	if err != nil {
+		return err
+	}
+
+	// fetched files were verified as they were written.
+	for path, hash := range fetch {
+		if err := cache.Put(path, hash); err != nil {
+			return err
+		}
+	}
+
+	if err := cache.Write(); err != nil {
+		p.index.pErr("Warning: could not write hash cache: %s\n", err)
+	}
+
+	p.index.pOut("data pack: %d up to date, %d fetched.\n", len(present),
+		len(fetch))
+
+	// restore file permissions and empty directories
+	return p.manifest.RestoreMeta(f, p.index.output())
 }
 
-// Publishes pack to the Index
-func (p *Pack) Publish(force bool) error {
+// Returns the blobs selected by f (all, if nil), the Manifest, and the
+// Datafile (which describes the dataset, and is read once installed).
+func (p *Pack) selectedBlobs(f *PathFilter) (blobPaths, error) {
+	blobs, err := p.BlobPaths()
+	if err != nil || f.Empty() {
+		return blobs, err
+	}
 
-	// ensure datafile has required info
-	if !p.datafile.Valid() {
-		return fmt.Errorf(`Datafile invalid. Try running 'data pack make'`)
+	selected := f.Blobs(blobs)
+	none := len(selected) == 0
+	for _, path := range []string{ManifestFileName, DatafileName} {
+		if h, found := blobs[path]; found {
+			selected[path] = h
+		}
 	}
 
-	// ensure manifest is complete
-	if !p.manifest.Complete() {
-		return fmt.Errorf(`Manifest incomplete. Before uploading, either:
-      - Generate new package manifest with 'data pack make' (uses all files).
-      - Finish manifest with 'data manifest' (add and hash specific files).
-    `)
+	p.index.pErr("Selected %d of %d files (%s).\n", len(selected)-1,
+		len(blobs)-1, f)
+	if none {
+		p.index.pErr("Warning: no files match (%s).\n", f)
+	}
+	return selected, nil
+}
+
+// Fills out Datafile defaults: <user>/<directory name>@1.0
+func (p *Pack) datafileDefaults() {
+	if len(p.datafile.Dataset) == 0 {
+		dir, _ := filepath.Abs(p.root) // ("" is the working directory)
+		name := identString(filepath.Base(dir))
+		p.datafile.Dataset = configUser() + "/" + name + "@1.0"
+	}
+}
+
+// Publishes pack to the Index
+func (p *Pack) Publish(force bool) error {
+	if err := p.checkPublishable(); err != nil {
+		return err
 	}
 
 	// ensure all blobs have been uploaded
@@ -426,13 +733,79 @@ func (p *Pack) Publish(force bool) error {
 
 	// Check dataset version isn't already taken.
 	h := p.datafile.Handle()
-	ri := p.index.RefIndex(h.Path())
-	ref, err := ri.VersionRef(h.Version)
+	ref, err := p.publishedRef(h)
+	if err != nil {
+		return err
+	}
+
+	if ref != "" {
+		p.index.pOut("Found published version %s (%.7s).\n", h.Version, ref)
+		if ref == mfh {
+			p.index.pOut(PublishedVersionSameMsg, h.Version, ref)
+			return nil
+		}
+
+		if !force {
+			return fmt.Errorf(PublishedVersionDiffersMsg, h.Version, ref, h.Dataset())
+		}
+
+		p.index.pOut("Using --force. Overwriting %s (%.7s -> %.7s).\n",
+			h.Version, ref, mfh)
+	}
+
+	// ok seems good to go.
+	err = p.index.RefIndex(h.Path()).Put(mfh)
+	if err != nil {
+		return err
+	}
+
+	p.index.pOut("data pack: published %s (%.7s).\n", h.Dataset(), mfh)
+	p.index.pOut("Webpage at %s/%s\n", p.index.Http.BaseUrl, h.Dataset())
+	return nil
+}
+
+// Checks the pack is ready to publish.
+func (p *Pack) checkPublishable() error {
+
+	// ensure datafile has required info
+	if !p.datafile.Valid() {
+		return fmt.Errorf(`Datafile invalid. Try running 'data pack make'`)
+	}
+
+	// ensure manifest is complete
+	if !p.manifest.Complete() {
+		return fmt.Errorf(`Manifest incomplete. Before uploading, either:
+      - Generate new package manifest with 'data pack make' (uses all files).
+      - Finish manifest with 'data manifest' (add and hash specific files).
+    `)
+	}
+
+	// ensure the dataset installs correctly on other filesystems.
+	if err := p.manifest.CheckPortable(); err != nil {
+		return err
+	}
+
+	// lint: report all problems, fail on errors.
+	r := p.Lint(p.index)
+	if len(r.Problems) > 0 {
+		r.printTo(p.index.output())
+	}
+	if r.Count(LintError) > 0 {
+		return fmt.Errorf("Datafile or Manifest has errors (see above). " +
+			"Fix them, and check with 'data lint'.")
+	}
+	return nil
+}
+
+// Returns the manifest hash published for h's version, or "" if the
+// version has not been published.
+func (p *Pack) publishedRef(h *Handle) (string, error) {
+	ref, err := p.index.RefIndex(h.Path()).VersionRef(h.Version)
 	if err != nil {
 		switch {
 		// http errors fail.
 		case strings.Contains(err.Error(), "connection refused"):
-			return fmt.Errorf(NetErrMsg, p.index.Http.Url)
+			return "", fmt.Errorf(NetErrMsg, p.index.Http.Url)
 
 		// ok if no ref for version.
 		case strings.Contains(err.Error(), "No ref for version"):
@@ -441,32 +814,41 @@ func (p *Pack) Publish(force bool) error {
 		case strings.Contains(err.Error(), "HTTP error status code: 404"):
 
 		default:
-			return err
+			return "", err
 		}
 	}
+	return ref, nil
+}
 
-	if ref != "" {
-		pOut("Found published version %s (%.7s).\n", h.Version, ref)
-		if ref == mfh {
-			pOut(PublishedVersionSameMsg, h.Version, ref)
-			return nil
-		}
+// Signs pack manifest, and uploads the signature.
+func (p *Pack) Sign() error {
+	if !p.datafile.Valid() {
+		return fmt.Errorf(`Datafile invalid. Try running 'data pack make'`)
+	}
 
-		if !force {
-			return fmt.Errorf(PublishedVersionDiffersMsg, h.Version, ref, h.Dataset())
-		}
+	if !p.manifest.Complete() {
+		return fmt.Errorf(ManifestIncompleteMsg)
+	}
+
+	mfh, err := p.manifest.ManifestHash()
+	if err != nil {
+		return err
+	}
 
-		pOut("Using --force. Overwriting %s (%.7s -> %.7s).\n", h.Version, ref, mfh)
+	key, err := configSigningKey()
+	if err != nil {
+		return err
 	}
 
-	// ok seems good to go.
-	err = ri.Put(mfh)
+	h := p.datafile.Handle()
+	s := SignManifest(h.Path(), mfh, key)
+	err = p.index.putSignature(s)
 	if err != nil {
 		return err
 	}
 
-	pOut("data pack: published %s (%.7s).\n", h.Dataset(), mfh)
-	pOut("Webpage at %s/%s\n", p.index.Http.BaseUrl, h.Dataset())
+	p.index.pOut("data pack: signed %s (%.7s) with key %.16s.\n", h.Dataset(),
+		mfh, s.Key)
 	return nil
 }
 
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// Packs in other directories (see NewPackAt) work on their own files,
+// whatever the working directory.
+func TestPacksAtRoots(t *testing.T) {
+	root, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	write := func(p string, contents string) {
+		f, err := createFile(path.Join(root, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(contents)
+		f.Close()
+	}
+
+	packs := map[string]*Pack{}
+	for _, name := range []string{"a", "b"} {
+		write(name+"/Datafile", "dataset: test/"+name+"@1.0\n")
+		write(name+"/x.csv", "x in "+name)
+		write(name+"/y.csv", "x in "+name) // (a local copy of x.csv)
+
+		p := newPackWithIndex(path.Join(root, name), &DataIndex{})
+		if err := p.manifest.Generate(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(p.manifest.Files); n != 3 || !p.manifest.Complete() {
+			t.Errorf("%s: manifest has %d files, complete: %v", name, n,
+				p.manifest.Complete())
+		}
+		if missing := p.manifest.MissingPaths(); len(missing) > 0 {
+			t.Errorf("%s: missing paths: %v", name, missing)
+		}
+		packs[name] = p
+	}
+
+	a, b := packs["a"].manifest, packs["b"].manifest
+	if a.Files["x.csv"] == b.Files["x.csv"] {
+		t.Error("packs hashed the same files")
+	}
+
+	// repair restores from the pack's own local copy.
+	write("b/y.csv", "corrupt")
+	if err := packs["b"].Repair(false); err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(path.Join(root, "b/y.csv"))
+	if string(buf) != "x in b" {
+		t.Errorf("repaired b/y.csv: %q", buf)
+	}
+
+	for name, p := range packs {
+		filename := path.Join(root, name+ArchiveSuffix)
+		if err := p.Archive(filename); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := path.Join(root, "out-"+name)
+		if err := UnarchivePack(filename, dst); err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadFile(path.Join(dst, "x.csv"))
+		if string(buf) != "x in "+name {
+			t.Errorf("%s: archived x.csv: %q", name, buf)
+		}
+	}
+}
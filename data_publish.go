@@ -1,7 +1,6 @@
 package data
 
 import (
-	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
 )
@@ -15,6 +14,15 @@ var cmd_data_publish = &commander.Command{
     create a data package (Datafile and Manifest), uploads it,
     and publishes it to the dataset index.
 
+    All 'data pack make' flags are accepted, to set Datafile fields. With
+    --yes (or --non-interactive), nothing is prompted for, and the guide
+    messages are not shown, so publishing can run unattended (e.g. in CI).
+    Exit codes: 0 success, 1 failure, 2 missing or invalid Datafile
+    fields, 3 not logged in or not allowed to publish.
+
+    With --dry-run, the package is not created (the existing Datafile and
+    Manifest are used), and only the upload and publish plans are shown.
+
     See 'data pack'.
   `,
 	Run:  publishCmd,
@@ -26,33 +34,54 @@ func init() {
 		"rebuild manifest (data pack make --clean)")
 	cmd_data_publish.Flag.Bool("force", false,
 		"force publish (data pack publish --force)")
+	cmd_data_publish.Flag.String("symlinks", "",
+		"manifest symlink policy (data pack make --symlinks)")
+	cmd_data_publish.Flag.Bool("merkle", false,
+		"use merkle root as ref (data pack make --merkle)")
+	cmd_data_publish.Flag.String("format", "",
+		"manifest format (data pack make --format)")
+	cmd_data_publish.Flag.Bool("dry-run", false,
+		"show upload and publish plans only")
+	addDatafileFlags(&cmd_data_publish.Flag)
 }
 
 func publishCmd(c *commander.Command, args []string) error {
 	u := configUser()
 	if !isNamedUser(u) {
-		return fmt.Errorf(NotLoggedInErr)
+		return ExitErrorf(ExitAuth, NotLoggedInErr)
+	}
+
+	// guide messages (not shown when running non-interactively).
+	guide := func(msg string) {
+		if !nonInteractive(c) {
+			pOut("%s", msg)
+		}
+	}
+
+	if c.Flag.Lookup("dry-run").Value.Get().(bool) {
+		pOut("==> Dry run: skipping package creation (see 'data pack make').\n")
+		return packPublishCmd(c, []string{})
 	}
 
 	pOut("==> Guided Data Package Publishing.\n")
-	pOut(PublishMsgWelcome)
+	guide(PublishMsgWelcome)
 
 	pOut("\n==> Step 1/3: Creating the package.\n")
-	pOut(PublishMsgDatafile)
+	guide(PublishMsgDatafile)
 	err := packMakeCmd(c, []string{})
 	if err != nil {
 		return err
 	}
 
 	pOut("\n==> Step 2/3: Uploading the package contents.\n")
-	pOut(PublishMsgUpload)
+	guide(PublishMsgUpload)
 	err = packUploadCmd(c, []string{})
 	if err != nil {
 		return err
 	}
 
 	pOut("\n==> Step 3/3: Publishing the package to the index.\n")
-	pOut(PublishMsgPublish)
+	guide(PublishMsgPublish)
 	return packPublishCmd(c, []string{})
 }
 
@@ -191,6 +191,7 @@ func (d *DataIndex) RefIndex(dataset string) *HttpRefIndex {
 			Url:       d.Http.Url + "/" + dataset + "/" + "refs",
 			User:      d.Http.User,
 			AuthToken: d.Http.AuthToken,
+			ctx:       d.Http.ctx,
 		},
 		Dataset: dataset,
 	}
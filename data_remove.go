@@ -0,0 +1,75 @@
+package data
+
+import (
+	"fmt"
+	"github.com/jbenet/commander"
+	"os"
+	"strings"
+)
+
+var cmd_data_remove = &commander.Command{
+	UsageLine: "remove <dataset>|<url>...",
+	Short:     "Remove dataset from Datafile dependencies.",
+	Long: `data remove - Remove dataset from Datafile dependencies.
+
+    Removes each <dataset> (<author>/<name>, any version, or url) from the
+    dependencies in the Datafile, and its saved sparse globs, if any.
+    Other Datafile fields and formatting are kept. Installed files are
+    not deleted.
+
+    The next 'data get' updates Datafile.lock (keeping the locked
+    versions of the remaining dependencies).
+
+  `,
+	Run: removeCmd,
+}
+
+func removeCmd(c *commander.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("%v requires a <dataset> argument.", c.FullName())
+	}
+
+	// check all are dependencies first, so none are removed otherwise.
+	df, err := NewDefaultDatafile()
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error reading %s: %s", DatafileName, err)
+	}
+
+	for _, ds := range args {
+		if !isDependency(df, ds) {
+			return fmt.Errorf("%s is not a dependency in %s.", ds, DatafileName)
+		}
+	}
+
+	for _, ds := range args {
+		if _, err := RemoveDependency(DatafileName, ds); err != nil {
+			return err
+		}
+
+		if isUrl(ds) {
+			pOut("Removed %s from %s.\n", ds, DatafileName)
+			continue
+		}
+		h := NewHandle(strings.ToLower(ds))
+
+		// drop saved sparse globs.
+		if df.DependencyFilter(h) != nil {
+			if err := SaveDependencyFilter(DatafileName, h, nil); err != nil {
+				return err
+			}
+		}
+
+		pOut("Removed %s from %s.\n", h.Path(), DatafileName)
+	}
+	return nil
+}
+
+// Whether ds (<author>/<name>, any version, or a url) is a dependency in df.
+func isDependency(df *Datafile, ds string) bool {
+	for _, s := range df.Dependencies {
+		if s == ds {
+			return true
+		}
+	}
+	return !isUrl(ds) && df.HasDependency(NewHandle(strings.ToLower(ds)))
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"fmt"
+	"github.com/jbenet/commander"
+	"os"
+	"strings"
+)
+
+var cmd_data_update = &commander.Command{
+	UsageLine: "update [<dataset>...]",
+	Short:     "Update datasets within Datafile version ranges.",
+	Long: `data update - Update datasets within Datafile version ranges.
+
+    Resolves the Datafile dependencies again, and installs the latest
+    versions satisfying all version ranges (see 'data get'). Versions
+    outside the ranges are never installed: to move to a new major
+    version, change the range in the Datafile (or 'data get --save').
+
+    With <dataset> arguments (<author>/<name>), only those datasets are
+    updated: others keep their versions in Datafile.lock, unless the
+    updated datasets require other versions. Without arguments, all
+    dependencies are updated.
+
+    Datafile.lock is rewritten, and the previously locked versions of
+    updated datasets are removed from the dataset working directory.
+    Dependencies that are urls are not updated. See 'data outdated' for
+    the versions available.
+
+  `,
+	Run: updateCmd,
+}
+
+func updateCmd(c *commander.Command, args []string) error {
+	df, _ := NewDefaultDatafile()
+	_, handles := splitUrls(df.Dependencies)
+	if len(handles) == 0 {
+		return fmt.Errorf("%v: no dependencies in %s.", c.FullName(),
+			DatafileName)
+	}
+
+	deps, err := parseDependencies(handles, "")
+	if err != nil {
+		return err
+	}
+
+	di, err := NewMainDataIndex()
+	if err != nil {
+		return err
+	}
+
+	lf, err := NewDefaultLockfile()
+	if err != nil {
+		pErr("Warning: ignoring %s. %s\n", LockfileName, err)
+	}
+	locked := lf.Versions()
+
+	// keep the locked versions of datasets not named.
+	var prefer map[string]string
+	named := map[string]bool{}
+	if len(args) > 0 {
+		prefer = lf.Versions()
+		for _, ds := range args {
+			h := NewHandle(strings.ToLower(ds))
+			if !PathRegexp.MatchString(h.Path()) {
+				return fmt.Errorf("%v: invalid dataset %q. Should be "+
+					"<author>/<name>.", c.FullName(), ds)
+			}
+			named[h.Path()] = true
+			delete(prefer, h.Path())
+		}
+	}
+
+	plan, err := ResolveDependencies(di, deps, prefer)
+	if err != nil {
+		return err
+	}
+
+	for p, _ := range named {
+		if !planHas(plan, p) {
+			return fmt.Errorf(UpdateNotDependencyMsg, p, DatafileName, p)
+		}
+	}
+
+	updated := 0
+	for _, s := range plan.Datasets {
+		h := s.Handle()
+		old := locked[s.Path]
+		if _, err := os.Stat(h.InstallPath()); err == nil && old == s.Version {
+			continue // up to date.
+		}
+
+		err := di.installDataset("", h, s.Ref, df.DependencyFilter(h))
+		if err != nil {
+			return err
+		}
+		updated++
+
+		if len(old) == 0 || old == s.Version {
+			pOut("Installed %s.\n", h.Dataset())
+			continue
+		}
+
+		pOut("Updated %s %s -> %s.\n", s.Path, old, s.Version)
+		prev := NewHandle(s.Path + "@" + old).InstallPath()
+		if err := os.RemoveAll(prev); err != nil {
+			pErr("Warning: could not remove %s: %s\n", prev, err)
+		}
+	}
+
+	lf.Set(df.Dependencies, plan, di)
+	if err := lf.WriteFile(); err != nil {
+		return err
+	}
+	pErr("Wrote %s.\n", LockfileName)
+
+	if updated == 0 {
+		pOut("All dependencies are up to date.\n")
+	}
+	return nil
+}
+
+func planHas(plan *InstallPlan, path string) bool {
+	for _, s := range plan.Datasets {
+		if s.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+const UpdateNotDependencyMsg = `Error: %s is not a dependency in %s (nor of
+its dependencies). Add it with:
+
+    data get --save %s
+`
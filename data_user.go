@@ -462,6 +462,7 @@ func (d *DataIndex) NewUserIndex(user string) *UserIndex {
 			Url:       d.Http.Url + "/" + user + "/" + "user",
 			User:      d.Http.User,
 			AuthToken: d.Http.AuthToken,
+			ctx:       d.Http.ctx,
 		},
 		User: user,
 	}
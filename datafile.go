@@ -1,6 +1,9 @@
 package data
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"path"
 )
 
@@ -13,7 +16,8 @@ import (
   title: Dataset Title
 
   # optional functionality
-  dependencies: [<other dataset handles>]
+  dependencies: [<author>/<name>[@<version range>], ...]
+  sparse: {<author>/<name> : {include: [<globs>], exclude: [<globs>]}}
   formats: {<format> : <format url>}
 
   # optional information
@@ -30,9 +34,10 @@ type datafileContents struct {
 	Dataset string
 	Tagline string
 
-	Mirrors      []string          ",omitempty"
-	Dependencies []string          ",omitempty"
-	Formats      map[string]string ",omitempty"
+	Mirrors      []string               ",omitempty"
+	Dependencies []string               ",omitempty"
+	Sparse       map[string]*PathFilter ",omitempty"
+	Formats      map[string]string      ",omitempty"
 
 	Description  string   ",omitempty"
 	Repository   string   ",omitempty"
@@ -73,11 +78,45 @@ func NewDefaultDatafile() (*Datafile, error) {
 }
 
 func NewDatafileWithRef(ref string) (*Datafile, error) {
-	f, _ := NewDatafile("")
-	err := f.ReadBlob(ref)
+	i, err := NewMainDataIndex()
 	if err != nil {
 		return nil, err
 	}
+
+	return i.datafileWithRef(ref)
+}
+
+// Returns the Datafile blob ref, checking its contents match ref.
+func (i *DataIndex) datafileWithRef(ref string) (*Datafile, error) {
+	r, err := i.remoteBlob(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return verifyDatafile(buf, ref)
+}
+
+// Parses Datafile buf, checking it matches ref (its hash).
+func verifyDatafile(buf []byte, ref string) (*Datafile, error) {
+	vh, err := readerHash(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if vh != ref {
+		return nil, fmt.Errorf("Datafile hash error (expected %s, got %s)",
+			ref, vh)
+	}
+
+	f, _ := NewDatafile("")
+	if err := f.Unmarshal(buf); err != nil {
+		return nil, err
+	}
 	return f, nil
 }
 
@@ -89,6 +128,34 @@ func (d *Datafile) Valid() bool {
 	return d.Handle().Valid()
 }
 
+// Whether dataset h is one of the Datafile's dependencies.
+func (d *Datafile) HasDependency(h *Handle) bool {
+	for _, dep := range d.Dependencies {
+		if d, err := ParseDependency(dep); err == nil && d.Path == h.Path() {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path filter for dependency h (nil if it is not sparse).
+func (d *Datafile) DependencyFilter(h *Handle) *PathFilter {
+	return d.Sparse[h.Path()]
+}
+
+// Sets the path filter for dependency h (clears it if f is empty).
+func (d *Datafile) SetDependencyFilter(h *Handle, f *PathFilter) {
+	if f.Empty() {
+		delete(d.Sparse, h.Path())
+		return
+	}
+
+	if d.Sparse == nil {
+		d.Sparse = map[string]*PathFilter{}
+	}
+	d.Sparse[h.Path()] = f
+}
+
 // datafile manipulation utils
 
 // Return array of all Datafiles
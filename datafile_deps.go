@@ -0,0 +1,267 @@
+package data
+
+import (
+	"fmt"
+	"io/ioutil"
+	"launchpad.net/goyaml"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Editing Datafile dependencies (data get --save, data remove).
+//
+// The Datafile is edited in place: only the dependencies list (in the
+// same style, block or flow), or the sparse globs, are rewritten, so other
+// fields, comments, and formatting are preserved. If the edit cannot be
+// verified (unusual YAML), the Datafile is rewritten entirely.
+
+// Adds dependency dep to the Datafile at path, replacing any dependency on
+// the same dataset. Creates the Datafile if needed.
+func SaveDependency(path string, dep string) error {
+	if isUrl(dep) {
+		return editDependencies(path, func(deps []string) []string {
+			for _, s := range deps {
+				if s == dep {
+					return deps
+				}
+			}
+			return append(deps, dep)
+		})
+	}
+
+	d, err := ParseDependency(dep)
+	if err != nil {
+		return err
+	}
+
+	return editDependencies(path, func(deps []string) []string {
+		for i, s := range deps {
+			if o, err := ParseDependency(s); err == nil && o.Path == d.Path {
+				deps[i] = dep
+				return deps
+			}
+		}
+		return append(deps, dep)
+	})
+}
+
+// Removes the dependency on dataset dep (<author>/<name>, any version, or
+// a url) from the Datafile at path. Returns whether it was a dependency.
+func RemoveDependency(path string, dep string) (bool, error) {
+	h := NewHandle(strings.ToLower(dep))
+	found := false
+	err := editDependencies(path, func(deps []string) []string {
+		kept := []string{}
+		for _, s := range deps {
+			o, err := ParseDependency(s)
+			if s == dep || (err == nil && o.Path == h.Path()) {
+				found = true
+				continue
+			}
+			kept = append(kept, s)
+		}
+		return kept
+	})
+	return found, err
+}
+
+func editDependencies(path string, edit func([]string) []string) error {
+	return editDatafile(path, func(df *Datafile, doc string) string {
+		df.Dependencies = edit(append([]string{}, df.Dependencies...))
+		return replaceYAMLList(doc, "dependencies", df.Dependencies)
+	})
+}
+
+// Sets the saved sparse globs of dependency h (clears them if f is empty)
+// in the Datafile at path.
+func SaveDependencyFilter(path string, h *Handle, f *PathFilter) error {
+	return editDatafile(path, func(df *Datafile, doc string) string {
+		df.SetDependencyFilter(h, f)
+		if len(df.Sparse) == 0 {
+			return replaceYAMLBlock(doc, "sparse", "")
+		}
+
+		block, err := goyaml.Marshal(map[string]interface{}{
+			"sparse": df.Sparse,
+		})
+		if err != nil {
+			return "" // (rewritten entirely)
+		}
+		return replaceYAMLBlock(doc, "sparse", string(block))
+	})
+}
+
+// Edits the Datafile at path: edit changes df, and returns doc (the
+// Datafile's text) with the same changes.
+func editDatafile(path string, edit func(df *Datafile, doc string) string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	df, _ := NewDatafile("")
+	df.Path = path
+	if err := df.Unmarshal(buf); err != nil {
+		return fmt.Errorf("Error reading %s: %s", path, err)
+	}
+	dataset, tagline := df.Dataset, df.Tagline
+	out := edit(df, string(buf))
+
+	// verify the edit, else rewrite the whole Datafile.
+	check, _ := NewDatafile("")
+	err = check.Unmarshal([]byte(out))
+	if err != nil || !sameStrings(check.Dependencies, df.Dependencies) ||
+		!sameFilters(check.Sparse, df.Sparse) ||
+		check.Dataset != dataset || check.Tagline != tagline {
+		dOut("rewriting %s (could not edit it in place)\n", path)
+		return df.WriteFile()
+	}
+
+	return ioutil.WriteFile(path, []byte(out), 0666)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFilters(a, b map[string]*PathFilter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, f := range a {
+		o, found := b[p]
+		if !found || f.Empty() != o.Empty() || (!f.Empty() &&
+			(!sameStrings(f.Include, o.Include) ||
+				!sameStrings(f.Exclude, o.Exclude))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces the values of top-level YAML list key in doc (removing the key
+// if values is empty, appending it if missing), keeping its style.
+func replaceYAMLList(doc string, key string, values []string) string {
+	lines := strings.SplitAfter(doc, "\n")
+	start, end, flow, indent := yamlKeyLines(lines, key)
+
+	repl := ""
+	switch {
+	case len(values) == 0:
+	case flow:
+		quoted := []string{}
+		for _, v := range values {
+			quoted = append(quoted, yamlScalar(v))
+		}
+		repl = key + ": [" + strings.Join(quoted, ", ") + "]\n"
+	default:
+		repl = yamlBlockList(key, indent, values)
+	}
+
+	return replaceYAMLLines(lines, start, end, repl)
+}
+
+// Replaces top-level YAML key in doc (and its value) with block (removing
+// the key if block is empty, appending block if the key is missing).
+func replaceYAMLBlock(doc string, key string, block string) string {
+	lines := strings.SplitAfter(doc, "\n")
+	start, end, _, _ := yamlKeyLines(lines, key)
+	return replaceYAMLLines(lines, start, end, block)
+}
+
+func replaceYAMLLines(lines []string, start, end int, repl string) string {
+	if start < 0 {
+		doc := strings.Join(lines, "")
+		if len(repl) == 0 {
+			return doc
+		}
+		if len(doc) > 0 && !strings.HasSuffix(doc, "\n") {
+			doc += "\n"
+		}
+		return doc + repl
+	}
+
+	return strings.Join(lines[:start], "") + repl + strings.Join(lines[end:], "")
+}
+
+// Returns the lines [start, end) of top-level key in lines (start is -1
+// if missing), whether its value is in flow style, and the indent of its
+// block list items ("- " if none).
+func yamlKeyLines(lines []string, key string) (start int, end int,
+	flow bool, indent string) {
+
+	start = -1
+	for i, l := range lines {
+		if strings.HasPrefix(l, key+":") {
+			start = i
+			break
+		}
+	}
+
+	indent = "- "
+	if start < 0 {
+		return start, start, false, indent
+	}
+
+	rest := strings.TrimSpace(lines[start][len(key)+1:])
+	end = start + 1
+	flow = strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, "{")
+	if flow {
+		// [a, b] or {a: b} (possibly over several lines)
+		closing := "]"
+		if strings.HasPrefix(rest, "{") {
+			closing = "}"
+		}
+		for l := lines[start]; !strings.Contains(l, closing) && end < len(lines); end++ {
+			l = lines[end]
+		}
+	} else {
+		// indented lines, and "- " items
+		for ; end < len(lines); end++ {
+			l := lines[end]
+			t := strings.TrimSpace(l)
+			if len(t) > 0 && !strings.HasPrefix(l, " ") &&
+				!strings.HasPrefix(l, "\t") && !strings.HasPrefix(l, "-") &&
+				!strings.HasPrefix(l, "#") {
+				break
+			}
+			if strings.HasPrefix(t, "- ") && indent == "- " {
+				indent = l[:strings.Index(l, "- ")+2]
+			}
+		}
+
+		// keep trailing blank lines and comments.
+		for end > start+1 && (len(strings.TrimSpace(lines[end-1])) == 0 ||
+			strings.HasPrefix(lines[end-1], "#")) {
+			end--
+		}
+	}
+	return start, end, flow, indent
+}
+
+func yamlBlockList(key, indent string, values []string) string {
+	s := key + ":\n"
+	for _, v := range values {
+		s += indent + yamlScalar(v) + "\n"
+	}
+	return s
+}
+
+// Quotes s if it is not a plain YAML scalar.
+func yamlScalar(s string) string {
+	if len(s) == 0 || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s, ":#,[]{}\"'\\") ||
+		strings.ContainsAny(s[:1], "-?!&*|>%@`") {
+		return strconv.Quote(s)
+	}
+	return s
+}
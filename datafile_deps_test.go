@@ -0,0 +1,146 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplaceYAMLList(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		values   []string
+		expected string
+	}{
+		{"block",
+			"dataset: a/b\ndependencies:\n- c/d\n- e/f\nlicense: MIT\n",
+			[]string{"c/d", "g/h@^1.0"},
+			"dataset: a/b\ndependencies:\n- c/d\n- g/h@^1.0\nlicense: MIT\n"},
+		{"indented block",
+			"dependencies:\n    - c/d\n    - e/f\n",
+			[]string{"x/y"},
+			"dependencies:\n    - x/y\n"},
+		{"flow",
+			"dataset: a/b\ndependencies: [c/d, e/f]\nlicense: MIT\n",
+			[]string{"c/d", "e/f", "g/h@>=1 <2"},
+			"dataset: a/b\ndependencies: [c/d, e/f, g/h@>=1 <2]\nlicense: MIT\n"},
+		{"multi-line flow",
+			"dependencies: [\n  c/d,\n  e/f\n]\nlicense: MIT\n",
+			[]string{"e/f"},
+			"dependencies: [e/f]\nlicense: MIT\n"},
+		{"missing key",
+			"dataset: a/b\nlicense: MIT",
+			[]string{"c/d"},
+			"dataset: a/b\nlicense: MIT\ndependencies:\n- c/d\n"},
+		{"missing key, no values",
+			"dataset: a/b\n",
+			[]string{},
+			"dataset: a/b\n"},
+		{"empty doc",
+			"",
+			[]string{"c/d"},
+			"dependencies:\n- c/d\n"},
+		{"trailing comments",
+			"dependencies:\n  - c/d\n  # old: e/f\n  - g/h\n\n# licensing\nlicense: MIT\n",
+			[]string{"c/d"},
+			"dependencies:\n  - c/d\n\n# licensing\nlicense: MIT\n"},
+		{"comments before",
+			"# deps\ndependencies: [c/d] \n# end\n",
+			[]string{"c/d", "e/f"},
+			"# deps\ndependencies: [c/d, e/f]\n# end\n"},
+		{"removed",
+			"dataset: a/b\ndependencies:\n  - c/d\nlicense: MIT\n",
+			[]string{},
+			"dataset: a/b\nlicense: MIT\n"},
+		{"removed flow",
+			"dependencies: [c/d]\nlicense: MIT\n",
+			nil,
+			"license: MIT\n"},
+		{"quoted",
+			"dependencies: []\n",
+			[]string{"http://x.com/a.tar.gz", "-a", "a, b"},
+			"dependencies: [\"http://x.com/a.tar.gz\", \"-a\", \"a, b\"]\n"},
+		{"similar key",
+			"dependencies_old:\n- x/y\ndependencies:\n- c/d\n",
+			[]string{"e/f"},
+			"dependencies_old:\n- x/y\ndependencies:\n- e/f\n"},
+	}
+
+	for _, tt := range tests {
+		out := replaceYAMLList(tt.doc, "dependencies", tt.values)
+		if out != tt.expected {
+			t.Errorf("%s:\n%q\nexpected:\n%q", tt.name, out, tt.expected)
+		}
+	}
+}
+
+func TestReplaceYAMLBlock(t *testing.T) {
+	doc := "dataset: a/b\nsparse:\n  c/d:\n    include: [x]\n# license\nlicense: MIT\n"
+	block := "sparse:\n  e/f:\n    exclude: [y]\n"
+
+	expected := "dataset: a/b\n" + block + "# license\nlicense: MIT\n"
+	if out := replaceYAMLBlock(doc, "sparse", block); out != expected {
+		t.Errorf("replaced:\n%q\nexpected:\n%q", out, expected)
+	}
+
+	expected = "dataset: a/b\n# license\nlicense: MIT\n"
+	if out := replaceYAMLBlock(doc, "sparse", ""); out != expected {
+		t.Errorf("removed:\n%q\nexpected:\n%q", out, expected)
+	}
+
+	flow := "sparse: {c/d: {include: [x]}}\nlicense: MIT\n"
+	if out := replaceYAMLBlock(flow, "sparse", ""); out != "license: MIT\n" {
+		t.Errorf("removed flow: %q", out)
+	}
+}
+
+func TestSaveDependencyFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, DatafileName)
+	orig := "# my dataset\ndataset: a/b@1.0\n\ndependencies:\n  - c/d@^1.0 # pinned\n  - e/f\n\n# license\nlicense: MIT\n"
+	if err := ioutil.WriteFile(p, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewPathFilter("train", "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ds := range []string{"c/d", "e/f"} {
+		if err := SaveDependencyFilter(p, NewHandle(ds), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	df, err := NewDatafile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameFilters(df.Sparse, map[string]*PathFilter{"c/d": f, "e/f": f}) {
+		t.Errorf("sparse globs not saved: %v", df.Sparse)
+	}
+
+	buf, _ := ioutil.ReadFile(p)
+	if !strings.HasPrefix(string(buf), orig) {
+		t.Errorf("Datafile rewritten:\n%s", buf)
+	}
+
+	// clearing them restores the original.
+	for _, ds := range []string{"c/d", "e/f"} {
+		if err := SaveDependencyFilter(p, NewHandle(ds), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, _ = ioutil.ReadFile(p)
+	if string(buf) != orig {
+		t.Errorf("Datafile:\n%s\nexpected:\n%s", buf, orig)
+	}
+}
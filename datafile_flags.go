@@ -0,0 +1,199 @@
+package data
+
+import (
+	"fmt"
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	"os"
+	"strings"
+)
+
+// Non-interactive Datafile input (e.g. for CI).
+//
+// Every Datafile field can be set without prompting. In increasing order
+// of precedence, values come from:
+// - the existing Datafile
+// - a template Datafile (--template <file>, or DATA_TEMPLATE)
+// - environment variables (DATA_<FIELD>, e.g. DATA_TAGLINE)
+// - flags (--<field>, e.g. --tagline)
+//
+// List fields (authors, sources, ...) take comma-separated values.
+// With --yes (or --non-interactive, or DATA_NON_INTERACTIVE=1), nothing
+// is prompted for: missing required fields are an error.
+
+type datafileFlag struct {
+	Name  string
+	Usage string
+	Set   func(df *Datafile, h *Handle, v string)
+}
+
+var datafileFlags = []datafileFlag{
+	{"dataset", "dataset handle (<owner>/<name>@<version>)",
+		func(df *Datafile, h *Handle, v string) { h.SetDataset(v) }},
+	{"owner", "dataset owner id",
+		func(df *Datafile, h *Handle, v string) { h.Author = v }},
+	{"name", "dataset id",
+		func(df *Datafile, h *Handle, v string) { h.Name = v }},
+	{"version", "dataset version",
+		func(df *Datafile, h *Handle, v string) { h.Version = v }},
+	{"tagline", "tagline description",
+		func(df *Datafile, h *Handle, v string) { df.Tagline = v }},
+	{"description", "long description",
+		func(df *Datafile, h *Handle, v string) { df.Description = v }},
+	{"license", "license name",
+		func(df *Datafile, h *Handle, v string) { df.License = v }},
+	{"website", "dataset website url",
+		func(df *Datafile, h *Handle, v string) { df.Website = v }},
+	{"repository", "dataset repository url",
+		func(df *Datafile, h *Handle, v string) { df.Repository = v }},
+	{"authors", "authors (comma-separated)",
+		func(df *Datafile, h *Handle, v string) { df.Authors = splitList(v) }},
+	{"contributors", "contributors (comma-separated)",
+		func(df *Datafile, h *Handle, v string) { df.Contributors = splitList(v) }},
+	{"sources", "source urls (comma-separated)",
+		func(df *Datafile, h *Handle, v string) { df.Sources = splitList(v) }},
+	{"mirrors", "mirror urls (comma-separated)",
+		func(df *Datafile, h *Handle, v string) { df.Mirrors = splitList(v) }},
+	{"dependencies", "dependency handles (comma-separated)",
+		func(df *Datafile, h *Handle, v string) { df.Dependencies = splitList(v) }},
+}
+
+// Defines the Datafile input flags on fs.
+func addDatafileFlags(fs *flag.FlagSet) {
+	for _, f := range datafileFlags {
+		fs.String(f.Name, "", f.Usage)
+	}
+	fs.String("template", "", "template Datafile with field values")
+	fs.Bool("yes", false, "do not prompt (fail if required fields are missing)")
+	fs.Bool("non-interactive", false, "same as --yes")
+}
+
+// Returns the environment variable for Datafile field name.
+func datafileEnvVar(name string) string {
+	return "DATA_" + strings.ToUpper(name)
+}
+
+func splitList(s string) []string {
+	l := []string{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); len(e) > 0 {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// Whether the command runs without prompting.
+func nonInteractive(c *commander.Command) bool {
+	if c.Flag.Lookup("yes").Value.Get().(bool) ||
+		c.Flag.Lookup("non-interactive").Value.Get().(bool) {
+		return true
+	}
+
+	switch strings.ToLower(os.Getenv("DATA_NON_INTERACTIVE")) {
+	case "", "0", "false", "no":
+		return false
+	}
+	return true
+}
+
+// Sets Datafile fields from the template, environment, and flags.
+func applyDatafileFlags(c *commander.Command, df *Datafile) error {
+	tpl := c.Flag.Lookup("template").Value.Get().(string)
+	if len(tpl) == 0 {
+		tpl = os.Getenv(datafileEnvVar("template"))
+	}
+
+	if len(tpl) > 0 {
+		t, err := NewDatafile(tpl)
+		if err != nil {
+			return ExitErrorf(ExitUsage, "data: invalid template %s: %s", tpl, err)
+		}
+		df.merge(t)
+	}
+
+	h := df.Handle()
+	for _, f := range datafileFlags {
+		if v := os.Getenv(datafileEnvVar(f.Name)); len(v) > 0 {
+			f.Set(df, h, v)
+		}
+	}
+
+	for _, f := range datafileFlags {
+		if v := c.Flag.Lookup(f.Name).Value.Get().(string); len(v) > 0 {
+			f.Set(df, h, v)
+		}
+	}
+
+	df.Dataset = h.Dataset()
+	return nil
+}
+
+// Sets all fields that are set in t.
+func (d *Datafile) merge(t *Datafile) {
+	str := func(dst *string, src string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+	list := func(dst *[]string, src []string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+
+	str(&d.Dataset, t.Dataset)
+	str(&d.Tagline, t.Tagline)
+	str(&d.Description, t.Description)
+	str(&d.Repository, t.Repository)
+	str(&d.Website, t.Website)
+	str(&d.License, t.License)
+	list(&d.Mirrors, t.Mirrors)
+	list(&d.Dependencies, t.Dependencies)
+	list(&d.Authors, t.Authors)
+	list(&d.Contributors, t.Contributors)
+	list(&d.Sources, t.Sources)
+
+	for k, v := range t.Formats {
+		if d.Formats == nil {
+			d.Formats = map[string]string{}
+		}
+		d.Formats[k] = v
+	}
+
+	for k, v := range t.Sparse {
+		if d.Sparse == nil {
+			d.Sparse = map[string]*PathFilter{}
+		}
+		d.Sparse[k] = v
+	}
+}
+
+// Exit codes (see data/data.go). Errors without one exit with 1.
+const (
+	ExitFailure = 1 // command failed
+	ExitUsage   = 2 // invalid or missing input (flags, fields)
+	ExitAuth    = 3 // not logged in, or not allowed
+)
+
+// Error with an exit code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func ExitErrorf(code int, format string, args ...interface{}) error {
+	return &ExitError{code, fmt.Errorf(format, args...)}
+}
+
+// Returns the exit code for err.
+func ExitCode(err error) int {
+	if e, ok := err.(*ExitError); ok {
+		return e.Code
+	}
+	return ExitFailure
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"os"
 	"regexp"
 	"strings"
@@ -41,39 +42,51 @@ func fillOutDatafileInPath(path string) error {
 	return fillOutDatafile(df)
 }
 
-func fillOutDatafile(df *Datafile) error {
-	pOut("Writing Datafile fields...\n")
-	pOut("'Field description [current value]'\n")
+// Datafile input field, also settable with flag --<Flag>.
+type datafileInput struct {
+	InputField
+	Flag string
+}
 
-	h := df.Handle()
-	fields := []InputField{
-		InputField{
+// Returns the Datafile fields users fill out. Handle fields are set on h.
+func datafileInputs(df *Datafile, h *Handle) []datafileInput {
+	return []datafileInput{
+		datafileInput{InputField{
 			"owner id (required)",
 			&h.Author,
 			UserRegexp,
 			"Must be a valid username. Can only contain [a-z0-9-_.].",
-		},
-		InputField{
+		}, "owner"},
+		datafileInput{InputField{
 			"dataset id (required)",
 			&h.Name,
 			IdentRegexp,
 			"Must be a valid dataset id. Can only contain [a-z0-9-_.].",
-		},
-		InputField{
+		}, "name"},
+		datafileInput{InputField{
 			"dataset version (required)",
 			&h.Version,
 			IdentRegexp,
 			"Must be a valid version. Can only contain [a-z0-9-_.].",
-		},
-		InputField{"tagline description (required)", &df.Tagline, nil,
-			`A tagline is required to describe your package to others.
+		}, "version"},
+		datafileInput{InputField{"tagline description (required)", &df.Tagline,
+			nil, `A tagline is required to describe your package to others.
                Good taglines are like titles: short, descriptive phrases.`},
-		InputField{"long description (optional)", &df.Description, nil, ""},
-		InputField{"license name (optional)", &df.License, nil, ""},
+			"tagline"},
+		datafileInput{InputField{"long description (optional)", &df.Description,
+			nil, ""}, "description"},
+		datafileInput{InputField{"license name (optional)", &df.License, nil,
+			""}, "license"},
 	}
+}
 
-	for _, field := range fields {
-		err := fillOutField(field)
+func fillOutDatafile(df *Datafile) error {
+	pOut("Writing Datafile fields...\n")
+	pOut("'Field description [current value]'\n")
+
+	h := df.Handle()
+	for _, field := range datafileInputs(df, h) {
+		err := fillOutField(field.InputField)
 		if err != nil {
 			return err
 		}
@@ -90,21 +103,49 @@ func fillOutDatafile(df *Datafile) error {
 	return nil
 }
 
-func fillOutField(f InputField) error {
-
-	// validator function
-	valid := func(val string) bool {
-		if strings.Contains(f.Prompt, "required") && len(val) < 1 {
-			return false
+// Checks the Datafile has all required fields (without prompting), and
+// writes it. Fails listing every missing or invalid field.
+func checkDatafileInputs(df *Datafile) error {
+	h := df.Handle()
+	bad := []string{}
+	for _, field := range datafileInputs(df, h) {
+		if field.valid(*field.Value) {
+			continue
 		}
 
-		if f.Pattern != nil && !f.Pattern.MatchString(val) {
-			return false
+		problem := "missing"
+		if len(*field.Value) > 0 {
+			problem = fmt.Sprintf("invalid (%q)", *field.Value)
 		}
+		bad = append(bad, fmt.Sprintf("%s: %s. Set with --%s or %s.",
+			field.Prompt, problem, field.Flag, datafileEnvVar(field.Flag)))
+	}
 
-		return true
+	if len(bad) > 0 {
+		return ExitErrorf(ExitUsage, MissingDatafileFieldsMsg,
+			strings.Join(bad, "\n    "))
 	}
 
+	df.Dataset = h.Dataset()
+	return df.WriteFile()
+}
+
+// Whether val is valid for field f.
+func (f InputField) valid(val string) bool {
+	if strings.Contains(f.Prompt, "required") && len(val) < 1 {
+		return false
+	}
+
+	if f.Pattern != nil && !f.Pattern.MatchString(val) {
+		return false
+	}
+
+	return true
+}
+
+func fillOutField(f InputField) error {
+	valid := f.valid
+
 	for {
 		pOut("Enter %s [%s]: ", f.Prompt, *f.Value)
 		line, err := readInput()
@@ -154,3 +195,7 @@ func fillOutUserProfile(p *UserProfile) error {
 
 	return nil
 }
+
+const MissingDatafileFieldsMsg = `Datafile is missing required fields:
+    %s
+(Running non-interactively: fields are not prompted for. See 'data pack make'.)`
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stat/hash cache.
+//
+// Hashing every file of a large dataset is slow. File hashes are cached
+// (in .data/Hashcache), keyed by manifest path, and trusted as long as
+// the file's size and modification time have not changed. A missing or
+// corrupt cache is simply empty.
+//
+// Format: one line per file: <hash> <size> <mtime (ns)> <path>
+// (tabs, newlines, and backslashes in paths are escaped.)
+//
+// Each dataset directory (root) has its own cache; paths are relative to
+// the root.
+
+const HashCacheFileName = ".data/Hashcache"
+
+type hashCacheEntry struct {
+	Hash  string
+	Size  int64
+	Mtime int64
+}
+
+type hashCache struct {
+	Path    string
+	Root    string
+	entries map[string]*hashCacheEntry
+	changed bool
+}
+
+// Opens the cache of the dataset in directory root ("" for the working
+// directory).
+func openHashCache(root string) *hashCache {
+	c := &hashCache{
+		Path:    path.Join(root, HashCacheFileName),
+		Root:    root,
+		entries: map[string]*hashCacheEntry{},
+	}
+
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), " ", 4)
+		if len(fields) < 4 || !IsHash(fields[0]) {
+			dErr("data: ignoring invalid hash cache line: %q\n", s.Text())
+			continue
+		}
+
+		e := &hashCacheEntry{Hash: fields[0]}
+		e.Size, err = strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			e.Mtime, err = strconv.ParseInt(fields[2], 10, 64)
+		}
+		if err != nil {
+			dErr("data: ignoring invalid hash cache line: %q\n", s.Text())
+			continue
+		}
+		c.entries[recordUnescaper.Replace(fields[3])] = e
+	}
+	return c
+}
+
+func (e *hashCacheEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.Mtime == info.ModTime().UnixNano()
+}
+
+// Returns the hash of the file at (manifest) path p, rehashing it only if
+// it changed since it was cached. (The Manifest is never cached: it may
+// be named by its merkle root. See blobFileHash.)
+func (c *hashCache) Hash(p string) (string, error) {
+	if p == path.Clean(ManifestFileName) {
+		return blobFileHash(path.Join(c.Root, p))
+	}
+
+	info, err := os.Stat(localPath(path.Join(c.Root, p)))
+	if err != nil {
+		return "", err
+	}
+
+	if e, found := c.entries[p]; found && e.matches(info) {
+		return e.Hash, nil
+	}
+
+	h, info, err := hashFileInfo(path.Join(c.Root, p))
+	if err != nil {
+		return "", err
+	}
+
+	c.set(p, h, info)
+	return h, nil
+}
+
+// Records hash for the file at (manifest) path p, e.g. once it has been
+// downloaded (and verified).
+func (c *hashCache) Put(p string, hash string) error {
+	info, err := os.Stat(localPath(path.Join(c.Root, p)))
+	if err != nil {
+		return err
+	}
+
+	c.set(p, hash, info)
+	return nil
+}
+
+func (c *hashCache) set(p string, hash string, info os.FileInfo) {
+	e := &hashCacheEntry{hash, info.Size(), info.ModTime().UnixNano()}
+	if old, found := c.entries[p]; !found || *old != *e {
+		c.entries[p] = e
+		c.changed = true
+	}
+}
+
+// Writes the cache out, if it changed.
+func (c *hashCache) Write() error {
+	if !c.changed {
+		return nil
+	}
+
+	f, err := createFile(c.Path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for p, _ := range c.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	w := bufio.NewWriter(f)
+	for _, p := range paths {
+		e := c.entries[p]
+		fmt.Fprintf(w, "%s %d %d %s\n", e.Hash, e.Size, e.Mtime,
+			recordEscaper.Replace(p))
+	}
+
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), c.Path); err != nil {
+		return err
+	}
+
+	c.changed = false
+	return nil
+}
+
+// Returns the blobs { path : hash } already present locally, with the
+// right checksum.
+func upToDate(blobs blobPaths, cache *hashCache) blobPaths {
+	present := blobPaths{}
+	for p, hash := range blobs {
+		if h, err := cache.Hash(p); err == nil && h == hash {
+			present[p] = hash
+		}
+	}
+	return present
+}
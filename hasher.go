@@ -0,0 +1,132 @@
+package data
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// How often hashing progress is saved (the manifest written out, or its
+// stream journal synced) while hashing many files, so that it survives
+// interruption.
+const ManifestCheckpointInterval = 10 * time.Second
+
+// Result of hashing one file.
+type hashResult struct {
+	Path string
+	Hash string
+	Size int64
+	Info os.FileInfo
+	Err  error
+}
+
+// Hashes files (manifest paths of the dataset in directory root, "" for
+// the working directory) in parallel, using all available cores. Results
+// are sent on the returned channel (in completion order), which is closed
+// once all files are hashed. Closing stop halts hashing of further files.
+func hashFiles(root string, paths []string,
+	stop <-chan struct{}) <-chan hashResult {
+
+	workers := runtime.NumCPU()
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	in := make(chan string)
+	out := make(chan hashResult, workers)
+
+	// feed paths to workers
+	go func() {
+		defer close(in)
+		for _, p := range paths {
+			select {
+			case in <- p:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for n := 0; n < workers; n++ {
+		go func() {
+			defer wg.Done()
+			for p := range in {
+				h, info, err := hashFileInfo(path.Join(root, p))
+				r := hashResult{Path: p, Hash: h, Info: info, Err: err}
+				if info != nil {
+					r.Size = info.Size()
+				}
+				out <- r
+			}
+		}()
+	}
+
+	// close out once all workers are done
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Returns hash of file contents, and file info. (path is a manifest path)
+func hashFileInfo(path string) (string, os.FileInfo, error) {
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), info, nil
+}
+
+// Keeps track of hashing throughput.
+type hashStats struct {
+	Files int
+	Bytes int64
+	Start time.Time
+}
+
+func newHashStats() *hashStats {
+	return &hashStats{Start: time.Now()}
+}
+
+func (s *hashStats) Add(r hashResult) {
+	s.Files++
+	s.Bytes += r.Size
+}
+
+func (s *hashStats) String() string {
+	return s.format(time.Since(s.Start))
+}
+
+// Formats the stats after d elapsed (without a rate, if d is too short to
+// measure).
+func (s *hashStats) format(d time.Duration) string {
+	str := fmt.Sprintf("%d files (%s) in %s", s.Files, humanBytes(s.Bytes),
+		d-d%time.Millisecond)
+	if d <= 0 {
+		return str
+	}
+
+	rate := float64(s.Bytes) / d.Seconds()
+	return fmt.Sprintf("%s, %s/s", str, humanBytes(int64(rate)))
+}
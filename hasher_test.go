@@ -0,0 +1,24 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashStatsFormat(t *testing.T) {
+	s := &hashStats{Files: 2, Bytes: 2048}
+	tests := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{0, "2 files (2.0 KB) in 0s"},
+		{-time.Millisecond, "2 files (2.0 KB) in -1ms"},
+		{time.Second, "2 files (2.0 KB) in 1s, 2.0 KB/s"},
+	}
+
+	for _, tt := range tests {
+		if str := s.format(tt.d); str != tt.expected {
+			t.Errorf("%s: %q, expected %q", tt.d, str, tt.expected)
+		}
+	}
+}
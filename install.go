@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Atomic installation.
+//
+// Datasets are installed into a staging directory next to the install
+// directory (.<name>@<version>.installing-*), where every file is
+// verified as it is written. Only once the download succeeds is the
+// staging directory swapped in: the previous copy (if any) is moved aside
+// (.<name>@<version>.previous), the staging directory renamed into place,
+// and the previous copy removed. On failure, the staging directory is
+// removed, and the previous copy left (or put back) in place.
+//
+// The staging directory is seeded with the previous copy's files (hard
+// links, or copies) and hash cache, so unchanged files are not downloaded
+// again.
+//
+// Installs of one directory are serialized by a lock file
+// (.<name>@<version>.lock), kept fresh while the install runs. Installs
+// interrupted mid-swap, and their staging directories, are recovered by
+// the next install (once their lock is stale).
+
+type installStage struct {
+	Dir    string // install directory
+	Stage  string // staging directory
+	Backup string // previous copy, while swapping
+	Lock   string // lock file, while installing
+
+	out      io.Writer // where progress is printed (nil: pErr)
+	unlocked chan bool
+}
+
+// Locks older than this were abandoned (locks are refreshed every
+// installLockRefresh).
+const installLockStale = 2 * time.Minute
+const installLockRefresh = 20 * time.Second
+
+// Creates a staging directory to install dir, printing progress to out
+// (nil: pErr). Waiting for the install lock stops once ctx is done (nil:
+// never).
+func newInstallStage(ctx context.Context, dir string,
+	out io.Writer) (*installStage, error) {
+
+	parent, base := path.Split(dir)
+	s := &installStage{
+		Dir:    dir,
+		Backup: path.Join(parent, "."+base+".previous"),
+		Lock:   path.Join(parent, "."+base+".lock"),
+		out:    out,
+	}
+
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return nil, err
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.recover(); err != nil {
+		s.unlock()
+		return nil, err
+	}
+
+	stage, err := ioutil.TempDir(parent, "."+base+".installing-")
+	if err != nil {
+		s.unlock()
+		return nil, err
+	}
+
+	s.Stage = stage
+	return s, nil
+}
+
+// Takes the install lock, waiting for other installs of dir to finish (or
+// their lock to go stale), or until ctx is done.
+func (s *installStage) lock(ctx context.Context) error {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	waiting := false
+	for {
+		f, err := os.OpenFile(s.Lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		info, err := os.Stat(s.Lock)
+		if err == nil && time.Since(info.ModTime()) > installLockStale {
+			dOut("removing stale install lock %s\n", s.Lock)
+			os.Remove(s.Lock)
+			continue
+		}
+
+		if !waiting {
+			wErr(s.out, "Waiting for another install of %s.\n", s.Dir)
+			waiting = true
+		}
+
+		select {
+		case <-done:
+			return fmt.Errorf("waiting for install lock %s: %s", s.Lock,
+				ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	s.unlocked = make(chan bool)
+	go func(unlocked chan bool) {
+		t := time.NewTicker(installLockRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-unlocked:
+				return
+			case now := <-t.C:
+				os.Chtimes(s.Lock, now, now)
+			}
+		}
+	}(s.unlocked)
+	return nil
+}
+
+func (s *installStage) unlock() {
+	if s.unlocked == nil {
+		return
+	}
+
+	close(s.unlocked)
+	s.unlocked = nil
+	os.Remove(s.Lock)
+}
+
+// Restores the previous copy of an interrupted install, and removes stale
+// staging directories. (Called with the lock held: no other install of
+// dir is running.)
+func (s *installStage) recover() error {
+	if _, err := os.Stat(s.Backup); err == nil {
+		if _, err := os.Stat(s.Dir); os.IsNotExist(err) {
+			wErr(s.out, "Restoring %s (interrupted install).\n", s.Dir)
+			if err := os.Rename(s.Backup, s.Dir); err != nil {
+				return err
+			}
+		} else if err := os.RemoveAll(s.Backup); err != nil {
+			return err
+		}
+	}
+
+	parent, base := path.Split(s.Dir)
+	stale, _ := filepath.Glob(path.Join(parent, "."+base+".installing-*"))
+	for _, d := range stale {
+		dOut("removing stale staging directory %s\n", d)
+		os.RemoveAll(d)
+	}
+	return nil
+}
+
+// Seeds the staging directory with the files { (manifest) path : mode }
+// of the previous copy, if any, and its hash cache. Files are hard linked
+// (or copied, where links fail); seeded files must be replaced, not
+// written. Files whose mode will change (to mode, if not 0) are copied:
+// chmod would change the previous copy too.
+func (s *installStage) Seed(files map[string]os.FileMode) error {
+	paths := []string{HashCacheFileName}
+	for p, _ := range files {
+		paths = append(paths, p)
+	}
+
+	for _, p := range paths {
+		src := path.Join(s.Dir, p)
+		info, err := os.Lstat(localPath(src))
+		if err != nil || !info.Mode().IsRegular() {
+			continue // (missing, or not a file.)
+		}
+
+		dst := path.Join(s.Stage, p)
+		if _, err := os.Lstat(localPath(dst)); err == nil {
+			continue // (already staged, e.g. the Manifest.)
+		}
+
+		if err := os.MkdirAll(path.Dir(dst), 0777); err != nil {
+			return err
+		}
+
+		mode := files[p]
+		if mode != 0 && mode != info.Mode().Perm() {
+			err = copyFileInfo(src, dst, info)
+		} else if err = os.Link(localPath(src), localPath(dst)); err != nil {
+			err = copyFileInfo(src, dst, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies file src to dst, keeping its mode and modification time (which
+// the hash cache checks).
+func copyFileInfo(src string, dst string, info os.FileInfo) error {
+	r, err := os.Open(localPath(src))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(localPath(dst), os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(localPath(dst), info.ModTime(), info.ModTime())
+}
+
+// Swaps the staging directory in, replacing the previous copy.
+func (s *installStage) Commit() error {
+	previous := true
+	if _, err := os.Lstat(s.Dir); os.IsNotExist(err) {
+		previous = false
+	}
+
+	if previous {
+		if err := os.Rename(s.Dir, s.Backup); err != nil {
+			s.Abort()
+			return fmt.Errorf("Error installing %s: %s", s.Dir, err)
+		}
+	}
+
+	if err := os.Rename(s.Stage, s.Dir); err != nil {
+		if previous {
+			os.Rename(s.Backup, s.Dir) // roll back
+		}
+		s.Abort()
+		return fmt.Errorf("Error installing %s: %s", s.Dir, err)
+	}
+	defer s.unlock()
+
+	if previous {
+		if err := os.RemoveAll(s.Backup); err != nil {
+			wErr(s.out, "Warning: could not remove previous copy %s: %s\n",
+				s.Backup, err)
+		}
+	}
+	return nil
+}
+
+// Removes the staging directory (the previous copy is untouched).
+func (s *installStage) Abort() {
+	os.RemoveAll(s.Stage)
+	s.unlock()
+}
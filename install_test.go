@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestInstallStageSeed(t *testing.T) {
+	root, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := path.Join(root, "a/b@1.0")
+	for _, p := range []string{"x/a.csv", "b.csv", "old.csv", "mode.csv",
+		HashCacheFileName} {
+		f, err := createFile(path.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("old " + p)
+		f.Close()
+		os.Chmod(f.Name(), 0644)
+	}
+
+	s, err := newInstallStage(nil, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]os.FileMode{"x/a.csv": 0, "b.csv": 0644, "c.csv": 0,
+		"mode.csv": 0600}
+	if err := s.Seed(files); err != nil {
+		t.Fatal(err)
+	}
+
+	// files whose mode changes are copied: the previous copy keeps its mode.
+	if err := os.Chmod(path.Join(s.Stage, "mode.csv"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path.Join(dir, "mode.csv"))
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("previous copy's mode changed: %v (%v)", info.Mode(), err)
+	}
+
+	for p, seeded := range map[string]bool{"x/a.csv": true, "b.csv": true,
+		"c.csv": false, "old.csv": false, HashCacheFileName: true} {
+		_, err := os.Stat(path.Join(s.Stage, p))
+		if (err == nil) != seeded {
+			t.Errorf("%s: seeded %v, expected %v", p, err == nil, seeded)
+		}
+	}
+
+	// replacing a seeded file leaves the installed copy alone.
+	os.Remove(path.Join(s.Stage, "b.csv"))
+	ioutil.WriteFile(path.Join(s.Stage, "b.csv"), []byte("new"), 0644)
+	if buf, _ := ioutil.ReadFile(path.Join(dir, "b.csv")); string(buf) != "old b.csv" {
+		t.Errorf("installed copy changed: %q", buf)
+	}
+
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(path.Join(dir, "b.csv")); string(buf) != "new" {
+		t.Errorf("staged copy not installed: %q", buf)
+	}
+	if _, err := os.Stat(s.Lock); !os.IsNotExist(err) {
+		t.Errorf("lock not removed: %v", err)
+	}
+}
+
+func TestInstallStageLock(t *testing.T) {
+	root, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := path.Join(root, "a/b@1.0")
+	s1, err := newInstallStage(nil, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a concurrent install waits, and leaves the live stage alone.
+	stages := make(chan *installStage)
+	go func() {
+		s2, err := newInstallStage(nil, dir, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		stages <- s2
+	}()
+
+	select {
+	case <-stages:
+		t.Fatal("concurrent install did not wait")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := os.Stat(s1.Stage); err != nil {
+		t.Fatalf("live stage removed: %v", err)
+	}
+
+	// a cancelled install stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if s, err := newInstallStage(ctx, dir, nil); err == nil {
+		s.Abort()
+		t.Error("cancelled install took the lock")
+	}
+	s1.Abort()
+
+	s2 := <-stages
+	if s2 == nil {
+		return
+	}
+	defer s2.Abort()
+
+	// an abandoned lock is taken over.
+	old := time.Now().Add(-2 * installLockStale)
+	os.Chtimes(s2.Lock, old, old)
+	s3, err := newInstallStage(nil, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.Abort()
+}
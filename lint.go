@@ -0,0 +1,358 @@
+package data
+
+import (
+	"fmt"
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var cmd_data_lint = &commander.Command{
+	UsageLine: "lint",
+	Short:     "Check Datafile and Manifest for problems.",
+	Long: `data lint - Check Datafile and Manifest for problems.
+
+    Checks the package's Datafile and Manifest, and reports all problems
+    found at once. Errors must be fixed before publishing ('data publish'
+    and 'data pack publish' run the same checks). Warnings are
+    recommendations.
+
+    Datafile checks:
+    - dataset handle and tagline are present and valid.
+    - license is an SPDX license identifier (or expression), e.g. MIT,
+      Apache-2.0, CC-BY-4.0. See https://spdx.org/licenses/
+    - website, repository, sources, mirrors, and formats are valid urls.
+    - authors and contributors have the form "Name <email> (url)", with
+      email and url optional.
+    - dependencies are valid (<author>/<name>[@<version range>], or
+      urls), and resolve in the index (unless --offline).
+
+    Manifest checks:
+    - manifest is complete (all files hashed).
+    - paths are portable: relative, within the dataset, and not colliding
+      on case-insensitive filesystems.
+    - symlinks do not point outside the dataset.
+
+    Exits with an error if any errors are found (or any warnings, with
+    --strict).
+
+  `,
+	Run:  lintCmd,
+	Flag: *flag.NewFlagSet("data-lint", flag.ExitOnError),
+}
+
+func init() {
+	cmd_data_lint.Flag.Bool("offline", false, "do not resolve dependencies")
+	cmd_data_lint.Flag.Bool("strict", false, "fail on warnings too")
+}
+
+func lintCmd(c *commander.Command, args []string) error {
+	p, err := NewPack()
+	if err != nil {
+		return err
+	}
+
+	di := p.index
+	if c.Flag.Lookup("offline").Value.Get().(bool) {
+		di = nil
+	}
+
+	r := p.Lint(di)
+	r.Print()
+	return r.Err(c.Flag.Lookup("strict").Value.Get().(bool))
+}
+
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+type LintProblem struct {
+	Severity string
+	Field    string
+	Msg      string
+}
+
+type LintReport struct {
+	Problems []*LintProblem
+}
+
+func (r *LintReport) add(severity, field, format string, args ...interface{}) {
+	r.Problems = append(r.Problems,
+		&LintProblem{severity, field, fmt.Sprintf(format, args...)})
+}
+
+// Number of problems with severity.
+func (r *LintReport) Count(severity string) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Prints all problems (errors first), then totals.
+func (r *LintReport) Print() {
+	r.printTo(nil)
+}
+
+// Prints the report to w (or, if nil, as Print does).
+func (r *LintReport) printTo(w io.Writer) {
+	sort.Stable(lintProblems(r.Problems))
+	for _, p := range r.Problems {
+		wErr(w, "%-7s  %s: %s\n", p.Severity, p.Field, p.Msg)
+	}
+
+	if len(r.Problems) == 0 {
+		wOut(w, "data lint: no problems found.\n")
+		return
+	}
+	wErr(w, "data lint: %d errors, %d warnings.\n", r.Count(LintError),
+		r.Count(LintWarning))
+}
+
+// Returns an error if there are errors (or warnings, if strict).
+func (r *LintReport) Err(strict bool) error {
+	n := r.Count(LintError)
+	if strict {
+		n += r.Count(LintWarning)
+	}
+
+	if n > 0 {
+		return fmt.Errorf("data lint: %d problems must be fixed.", n)
+	}
+	return nil
+}
+
+type lintProblems []*LintProblem
+
+func (l lintProblems) Len() int           { return len(l) }
+func (l lintProblems) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l lintProblems) Less(i, j int) bool { return l[i].Severity < l[j].Severity }
+
+// Checks the Datafile and Manifest. Dependencies are resolved using di
+// (skipped if nil).
+func (p *Pack) Lint(di *DataIndex) *LintReport {
+	r := &LintReport{}
+	if _, err := os.Stat(p.datafile.Path); err != nil {
+		r.add(LintError, "Datafile", "missing. Run 'data pack make'.")
+	} else {
+		r.lintDatafile(p.datafile, di)
+	}
+
+	if _, err := os.Stat(p.manifest.Path); err != nil {
+		r.add(LintError, "Manifest", "missing. Run 'data pack make'.")
+	} else {
+		r.lintManifest(p.manifest)
+	}
+	return r
+}
+
+func (r *LintReport) lintDatafile(df *Datafile, di *DataIndex) {
+	if len(df.Dataset) == 0 {
+		r.add(LintError, "dataset", "missing.")
+	} else if !df.Valid() {
+		r.add(LintError, "dataset", "invalid handle %q. Should be "+
+			"<owner>/<name>@<version>.", df.Dataset)
+	} else if len(df.Handle().Version) == 0 {
+		r.add(LintWarning, "dataset", "no version in handle %q.", df.Dataset)
+	}
+
+	if len(df.Tagline) == 0 {
+		r.add(LintError, "tagline", "missing. Describe the dataset in a "+
+			"short phrase.")
+	}
+
+	if len(df.Description) == 0 {
+		r.add(LintWarning, "description", "missing.")
+	}
+
+	if len(df.License) == 0 {
+		r.add(LintWarning, "license", "missing. Use an SPDX license "+
+			"identifier, e.g. CC-BY-4.0.")
+	} else {
+		r.lintLicense(df.License)
+	}
+
+	r.lintURL("website", df.Website)
+	r.lintURL("repository", df.Repository)
+	for _, u := range df.Sources {
+		r.lintURL("sources", u)
+	}
+	for _, u := range df.Mirrors {
+		r.lintURL("mirrors", u)
+	}
+	formats := []string{}
+	for f, _ := range df.Formats {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	for _, f := range formats {
+		r.lintURL("formats", df.Formats[f])
+	}
+
+	if len(df.Authors) == 0 && len(df.Contributors) == 0 {
+		r.add(LintWarning, "authors", "missing.")
+	}
+	for _, a := range df.Authors {
+		r.lintPerson("authors", a)
+	}
+	for _, a := range df.Contributors {
+		r.lintPerson("contributors", a)
+	}
+
+	for _, dep := range df.Dependencies {
+		r.lintDependency(dep, di)
+	}
+
+	for d, _ := range df.Sparse {
+		if !df.HasDependency(NewHandle(d)) {
+			r.add(LintWarning, "sparse", "%s is not a dependency.", d)
+		}
+	}
+}
+
+func (r *LintReport) lintURL(field string, s string) {
+	if len(s) == 0 {
+		return
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		r.add(LintError, field, "invalid url %q.", s)
+	}
+}
+
+// "Name [<email>] [(url)]"
+func (r *LintReport) lintPerson(field string, s string) {
+	m := PersonRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		r.add(LintError, field, "%q should be \"Name <email> (url)\" (email "+
+			"and url optional).", s)
+		return
+	}
+
+	if len(m[2]) > 0 && !EmailRegexp.MatchString(m[2]) {
+		r.add(LintError, field, "invalid email in %q.", s)
+	}
+	if len(m[3]) > 0 {
+		r.lintURL(field, m[3])
+	}
+}
+
+var spdxIdRegexp = regexp.MustCompile(`^[A-Za-z0-9.-]+\+?$`)
+
+// Checks license is an SPDX license expression: identifiers combined
+// with AND, OR, WITH (and parentheses).
+func (r *LintReport) lintLicense(license string) {
+	s := strings.NewReplacer("(", " ", ")", " ").Replace(license)
+	tokens := strings.Fields(s)
+
+	exception := false
+	for i, t := range tokens {
+		op := i%2 == 1
+		switch {
+		case op && (t == "AND" || t == "OR" || t == "WITH"):
+			exception = t == "WITH"
+			continue
+		case op || !spdxIdRegexp.MatchString(t):
+			r.add(LintError, "license", "%q is not an SPDX license identifier "+
+				"(or expression), e.g. CC-BY-4.0. See https://spdx.org/licenses/",
+				license)
+			return
+		case exception:
+			continue // (exceptions are not checked.)
+		}
+
+		id := strings.TrimSuffix(t, "+")
+		if !knownLicense(id) && !strings.HasPrefix(id, "LicenseRef-") {
+			r.add(LintWarning, "license", "unknown SPDX license identifier %q.",
+				id)
+		}
+	}
+
+	if len(tokens)%2 == 0 {
+		r.add(LintError, "license", "incomplete license expression %q.",
+			license)
+	}
+}
+
+func knownLicense(id string) bool {
+	for _, l := range SPDXLicenses {
+		if strings.EqualFold(l, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Common SPDX license identifiers (https://spdx.org/licenses/).
+var SPDXLicenses = []string{
+	"0BSD", "AFL-3.0", "AGPL-3.0", "AGPL-3.0-only", "AGPL-3.0-or-later",
+	"Apache-1.1", "Apache-2.0", "Artistic-2.0", "BSD-2-Clause",
+	"BSD-3-Clause", "BSD-4-Clause", "BSL-1.0", "CC-BY-1.0", "CC-BY-2.0",
+	"CC-BY-2.5", "CC-BY-3.0", "CC-BY-4.0", "CC-BY-NC-4.0", "CC-BY-NC-ND-4.0",
+	"CC-BY-NC-SA-4.0", "CC-BY-ND-4.0", "CC-BY-SA-3.0", "CC-BY-SA-4.0",
+	"CC0-1.0", "CDDL-1.0", "CDLA-Permissive-1.0", "CDLA-Permissive-2.0",
+	"CDLA-Sharing-1.0", "EPL-1.0", "EPL-2.0", "EUPL-1.2", "GFDL-1.3",
+	"GPL-2.0", "GPL-2.0-only", "GPL-2.0-or-later", "GPL-3.0", "GPL-3.0-only",
+	"GPL-3.0-or-later", "ISC", "LGPL-2.1", "LGPL-2.1-only",
+	"LGPL-2.1-or-later", "LGPL-3.0", "LGPL-3.0-only", "LGPL-3.0-or-later",
+	"MIT", "MPL-2.0", "ODbL-1.0", "ODC-By-1.0", "OFL-1.1", "PDDL-1.0",
+	"Unlicense", "WTFPL", "Zlib",
+}
+
+func (r *LintReport) lintDependency(dep string, di *DataIndex) {
+	if isUrl(dep) {
+		r.lintURL("dependencies", dep)
+		return
+	}
+
+	d, err := ParseDependency(dep)
+	if err != nil {
+		r.add(LintError, "dependencies", "%s", err)
+		return
+	}
+
+	if di == nil {
+		return
+	}
+
+	_, err = newDependencyResolver(di).selectVersion(d.Path, []*Dependency{d})
+	if err != nil {
+		if strings.Contains(err.Error(), "connection refused") {
+			r.add(LintWarning, "dependencies", "could not resolve %s "+
+				"(index unreachable).", dep)
+			return
+		}
+		r.add(LintError, "dependencies", "%s does not resolve: %s", dep, err)
+	}
+}
+
+func (r *LintReport) lintManifest(mf *Manifest) {
+	if !mf.Complete() {
+		r.add(LintError, "manifest", "incomplete. Run 'data pack make'.")
+	}
+
+	if len(mf.Files) == 0 {
+		r.add(LintWarning, "manifest", "no files.")
+	} else if _, found := mf.Files[DatafileName]; !found {
+		r.add(LintWarning, "manifest", "Datafile not in manifest.")
+	}
+
+	for _, bad := range mf.InvalidPaths() {
+		r.add(LintError, "manifest", "%s", bad)
+	}
+
+	for _, g := range mf.PathCollisions() {
+		r.add(LintError, "manifest", "paths collide on case-insensitive "+
+			"filesystems: %s", strings.Join(g, ", "))
+	}
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+/*
+  # Datafile.lock format
+  # Written by data get. Commit it, so everyone installs the same bytes.
+
+  dependencies: [<Datafile dependencies, as locked>]
+  datasets:
+  - dataset: <author>/<name>@<version>
+    ref: <manifest hash>
+    index: <index url>
+*/
+
+const LockfileName = "Datafile.lock"
+
+type LockedDataset struct {
+	Dataset string // <author>/<name>@<version>
+	Ref     string // manifest ref
+	Index   string // url of the index it was resolved in
+}
+
+// Serializable into YAML
+type lockfileContents struct {
+	Dependencies []string         ",omitempty"
+	Datasets     []*LockedDataset ",omitempty"
+}
+
+type Lockfile struct {
+	SerializedFile   "-"
+	lockfileContents ",inline"
+}
+
+func NewLockfile(path string) (*Lockfile, error) {
+	lf := &Lockfile{SerializedFile: SerializedFile{Path: path}}
+	lf.SerializedFile.Format = lf
+
+	if len(path) > 0 {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return lf, nil // not locked yet.
+		}
+
+		err := lf.ReadFile()
+		if err != nil {
+			return lf, err
+		}
+	}
+	return lf, nil
+}
+
+func NewDefaultLockfile() (*Lockfile, error) {
+	return NewLockfile(LockfileName)
+}
+
+// Whether the lockfile locks exactly deps (the Datafile dependencies).
+func (l *Lockfile) Current(deps []string) bool {
+	if len(l.Datasets) == 0 || len(l.Dependencies) != len(deps) {
+		return false
+	}
+
+	a := append([]string{}, l.Dependencies...)
+	b := append([]string{}, deps...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the locked install plan. Datasets must come from index di.
+func (l *Lockfile) Plan(di *DataIndex) (*InstallPlan, error) {
+	plan := &InstallPlan{Locked: true}
+	for _, d := range l.Datasets {
+		if d.Index != di.Http.Url {
+			return nil, fmt.Errorf(LockfileIndexMsg, d.Dataset, d.Index,
+				di.Http.Url)
+		}
+
+		h := NewHandle(d.Dataset)
+		if !h.Valid() || len(h.Version) == 0 || !IsHash(d.Ref) {
+			return nil, fmt.Errorf("%s: invalid entry %q. Run 'data get "+
+				"--update' to regenerate it.", l.Path, d.Dataset)
+		}
+
+		plan.Datasets = append(plan.Datasets,
+			&PlannedDataset{Path: h.Path(), Version: h.Version, Ref: d.Ref})
+	}
+	return plan, nil
+}
+
+// Returns the locked versions { path : version }.
+func (l *Lockfile) Versions() map[string]string {
+	versions := map[string]string{}
+	for _, d := range l.Datasets {
+		h := NewHandle(d.Dataset)
+		versions[h.Path()] = h.Version
+	}
+	return versions
+}
+
+// Locks plan, resolved from deps in index di.
+func (l *Lockfile) Set(deps []string, plan *InstallPlan, di *DataIndex) {
+	l.Dependencies = append([]string{}, deps...)
+	l.Datasets = nil
+	for _, s := range plan.Datasets {
+		l.Datasets = append(l.Datasets, &LockedDataset{
+			Dataset: s.Handle().Dataset(),
+			Ref:     s.Ref,
+			Index:   di.Http.Url,
+		})
+	}
+}
+
+const LockfileIndexMsg = `Error: %s was locked from index %s, but the
+configured index is %s. Run 'data get --update' to resolve dependencies
+again in the configured index.`
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"fmt"
+	"launchpad.net/goyaml"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Metadata about a manifest file (or directory), besides its hash.
+// Hashes only ever cover file contents; metadata is used to plan
+// downloads, report progress, and faithfully reconstruct datasets.
+type FileMeta struct {
+	Size int64
+	Mode os.FileMode
+	Type string // media type (optional)
+}
+
+func NewFileMeta(path string, info os.FileInfo) *FileMeta {
+	m := &FileMeta{Mode: info.Mode().Perm()}
+	if !info.IsDir() {
+		m.Size = info.Size()
+		m.Type = mediaType(path)
+	}
+	return m
+}
+
+// Returns the media type of path (by extension), or "" if unknown. Types
+// are recorded in manifests, so they come from a fixed table (not the
+// host's mime tables): the same files always give the same manifest.
+func mediaType(path string) string {
+	return mediaTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+var mediaTypes = map[string]string{
+	".bz2":     "application/x-bzip2",
+	".csv":     "text/csv",
+	".gif":     "image/gif",
+	".gz":      "application/gzip",
+	".h5":      "application/x-hdf5",
+	".hdf5":    "application/x-hdf5",
+	".htm":     "text/html",
+	".html":    "text/html",
+	".jpeg":    "image/jpeg",
+	".jpg":     "image/jpeg",
+	".json":    "application/json",
+	".md":      "text/markdown",
+	".mp3":     "audio/mpeg",
+	".mp4":     "video/mp4",
+	".parquet": "application/vnd.apache.parquet",
+	".pdf":     "application/pdf",
+	".png":     "image/png",
+	".svg":     "image/svg+xml",
+	".tar":     "application/x-tar",
+	".tif":     "image/tiff",
+	".tiff":    "image/tiff",
+	".tsv":     "text/tab-separated-values",
+	".txt":     "text/plain",
+	".wav":     "audio/wav",
+	".xml":     "application/xml",
+	".yaml":    "application/yaml",
+	".yml":     "application/yaml",
+	".zip":     "application/zip",
+}
+
+// Serializable into YAML. One per manifest path.
+type manifestEntry struct {
+	Hash string ",omitempty"
+	Size int64  ",omitempty"
+	Mode string ",omitempty"
+	Type string ",omitempty"
+}
+
+func newManifestEntry(hash string, m *FileMeta) *manifestEntry {
+	e := &manifestEntry{Hash: hash}
+	if m != nil {
+		e.Size = m.Size
+		e.Mode = fmt.Sprintf("%04o", m.Mode.Perm())
+		e.Type = m.Type
+	}
+	return e
+}
+
+func (e *manifestEntry) FileMeta() *FileMeta {
+	m := &FileMeta{Size: e.Size, Type: e.Type}
+	if mode, err := strconv.ParseUint(e.Mode, 8, 32); err == nil {
+		m.Mode = os.FileMode(mode).Perm()
+	}
+	return m
+}
+
+// Serializable into YAML.
+type manifestContents struct {
+	Merkle   bool   ",omitempty"
+	Symlinks string ",omitempty"
+
+	Files map[string]*manifestEntry
+	Dirs  map[string]*manifestEntry ",omitempty"
+	Links map[string]string         ",omitempty"
+}
+
+// manifestFormat (de)serializes a Manifest. Manifests without metadata
+// are written in the original, flat format:
+//
+//	<path>: <hash>
+//
+// so that their hashes (refs) remain the same. Manifests in stream
+// format (Stream, see manifest_stream.go) stay streams, whatever their
+// size. Otherwise, they are written as:
+//
+//	merkle: <whether ref is merkle root>
+//	symlinks: <policy>
+//	files:
+//	  <path>: { hash: <hash>, size: <bytes>, mode: <perm>, type: <type> }
+//	dirs:
+//	  <path>: { mode: <perm> }
+//	links:
+//	  <path>: <target>
+//
+// All formats are read.
+type manifestFormat Manifest
+
+func (f *manifestFormat) MarshalFile() ([]byte, error) {
+	if f.Stream {
+		return (*Manifest)(f).marshalStream()
+	}
+	return goyaml.Marshal(f)
+}
+
+func (f *manifestFormat) UnmarshalFile(buf []byte) error {
+	if isManifestStream(buf) {
+		f.SetYAML("", nil)
+		f.Stream = true
+		return (*Manifest)(f).unmarshalStream(buf)
+	}
+	return goyaml.Unmarshal(buf, f)
+}
+
+func (f *manifestFormat) GetYAML() (string, interface{}) {
+	flat := len(f.Meta) == 0 && len(f.Dirs) == 0 && len(f.Links) == 0
+	flat = flat && !f.Merkle
+	if flat && (f.Symlinks == "" || f.Symlinks == SymlinksFollow) {
+		return "", f.Files
+	}
+
+	c := manifestContents{Files: map[string]*manifestEntry{}}
+	c.Merkle = f.Merkle
+	c.Symlinks = f.Symlinks
+	c.Links = f.Links
+	for path, hash := range f.Files {
+		c.Files[path] = newManifestEntry(hash, f.Meta[path])
+	}
+
+	if len(f.Dirs) > 0 {
+		c.Dirs = map[string]*manifestEntry{}
+		for path, m := range f.Dirs {
+			c.Dirs[path] = newManifestEntry("", m)
+		}
+	}
+
+	return "", c
+}
+
+func (f *manifestFormat) SetYAML(tag string, value interface{}) bool {
+	f.Files = blobPaths{}
+	f.Meta = map[string]*FileMeta{}
+	f.Dirs = map[string]*FileMeta{}
+	f.Links = map[string]string{}
+	f.Symlinks = ""
+	f.Merkle = false
+	f.Stream = false
+
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return value == nil // empty document
+	}
+
+	// flat format: all values are hashes.
+	if _, ok := m["files"].(map[interface{}]interface{}); !ok {
+		for path, hash := range m {
+			f.Files[fmt.Sprint(path)] = fmt.Sprint(hash)
+		}
+		return true
+	}
+
+	c := manifestContents{}
+	if err := MarshalUnmarshal(m, &c); err != nil {
+		dErr("data manifest: %s\n", err)
+		return false
+	}
+
+	for path, e := range c.Files {
+		f.Files[path] = e.Hash
+
+		// (files added but never hashed have no metadata)
+		if len(e.Mode) > 0 {
+			f.Meta[path] = e.FileMeta()
+		}
+	}
+
+	for path, e := range c.Dirs {
+		f.Dirs[path] = e.FileMeta()
+	}
+
+	for path, target := range c.Links {
+		f.Links[path] = target
+	}
+
+	f.Symlinks = c.Symlinks
+	f.Merkle = c.Merkle
+	return true
+}
@@ -0,0 +1,490 @@
+package data
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var cmd_data_manifest_import = &commander.Command{
+	UsageLine: "import <file>",
+	Short:     "Imports checksums from a sha1sum file or BagIt bag.",
+	Long: `data manifest import - Imports checksums from a sha1sum file or BagIt bag.
+
+    Adds the files listed in <file> to the manifest. Supported formats:
+
+      sha1sum         Output of sha1sum (e.g. SHA1SUMS files).
+      sha256sum       Output of sha256sum (also md5sum, sha512sum).
+      bagit           A BagIt bag (directory with bagit.txt).
+
+    Paths in checksum files are relative to the directory of the file,
+    and paths in bags are relative to the bag directory. All paths must
+    be inside the dataset directory.
+
+    Manifests use sha1 checksums, so these are imported as is. Files
+    listed with other checksums are hashed (with sha1) when imported.
+    With --verify, files are also checked against the imported checksums
+    (which requires reading them all).
+
+    See 'data manifest'.
+
+Arguments:
+
+    <file>   checksum file, or bag directory.
+
+  `,
+	Run:  manifestImportCmd,
+	Flag: *flag.NewFlagSet("data-manifest-import", flag.ExitOnError),
+}
+
+var cmd_data_manifest_export = &commander.Command{
+	UsageLine: "export [--format sha1sum | bagit] [<path>]",
+	Short:     "Exports manifest as a sha1sum file or BagIt bag.",
+	Long: `data manifest export - Exports manifest as a sha1sum file or BagIt bag.
+
+    Outputs the manifest in a format other tools understand:
+
+      sha1sum         sha1sum output (default). Written to <path>, or
+                      stdout. Check with 'sha1sum -c <path>'.
+      bagit           BagIt bag (v1.0), created at <path> (required).
+                      Dataset files are hard-linked (or copied) into
+                      <path>/data.
+
+    All files must be hashed (see 'data manifest hash --unhashed').
+
+    See 'data manifest'.
+
+Arguments:
+
+    <path>   output file (sha1sum), or bag directory (bagit).
+
+  `,
+	Run:  manifestExportCmd,
+	Flag: *flag.NewFlagSet("data-manifest-export", flag.ExitOnError),
+}
+
+func init() {
+	cmd_data_manifest_import.Flag.Bool("verify", false, "verify imported checksums")
+	cmd_data_manifest_export.Flag.String("format", "sha1sum", "export format")
+}
+
+func manifestImportCmd(c *commander.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("%v: requires <file> argument.", c.FullName())
+	}
+
+	l, err := ReadChecksumListOrBag(args[0])
+	if err != nil {
+		return err
+	}
+
+	verify := c.Flag.Lookup("verify").Value.Get().(bool)
+	mf := NewDefaultManifest()
+	return mf.Import(l, verify)
+}
+
+func manifestExportCmd(c *commander.Command, args []string) error {
+	mf := NewDefaultManifest()
+	if !mf.Complete() {
+		return fmt.Errorf(ManifestIncompleteMsg)
+	}
+
+	format := c.Flag.Lookup("format").Value.Get().(string)
+	switch format {
+	case "sha1sum":
+		w := io.Writer(os.Stdout)
+		if len(args) > 0 {
+			f, err := createFile(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return mf.WriteChecksumList(w)
+
+	case "bagit":
+		if len(args) < 1 {
+			return fmt.Errorf("%v: bagit requires <path> argument.", c.FullName())
+		}
+		return mf.WriteBag(args[0])
+	}
+
+	return fmt.Errorf("%v: unknown format '%s'. Use one of: sha1sum, bagit.",
+		c.FullName(), format)
+}
+
+// Checksum algorithms, by name (as in BagIt manifest names).
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"md5":    md5.New,
+}
+
+// Returns the algorithm for a hex checksum, by its length.
+func checksumAlgorithm(sum string) string {
+	switch len(sum) {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	}
+	return ""
+}
+
+// A list of file checksums, imported from another format. Paths are
+// relative to the working directory.
+type ChecksumList struct {
+	Algorithm string
+	Sums      map[string]string // { path : checksum }
+}
+
+func ReadChecksumListOrBag(path string) (*ChecksumList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return ReadBag(path)
+	}
+	return ReadChecksumList(path)
+}
+
+// Reads a sha1sum (or sha256sum, ...) file. Lines are either:
+//
+//	<checksum>  <path>
+//	<checksum> *<path>
+//
+// Paths are relative to the directory of the file.
+func ReadChecksumList(path string) (*ChecksumList, error) {
+	l, err := readChecksumLines(path, func(line string) (string, string) {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) < 2 || len(parts[1]) < 1 {
+			return "", ""
+		}
+
+		// second char is ' ' (text mode) or '*' (binary mode)
+		return parts[0], parts[1][1:]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, l.rebase(filepath.Dir(path))
+}
+
+// Reads a BagIt bag's payload manifest, preferring sha1. Paths are
+// relative to the bag directory.
+func ReadBag(dir string) (*ChecksumList, error) {
+	if _, err := os.Stat(filepath.Join(dir, "bagit.txt")); err != nil {
+		return nil, fmt.Errorf("%s is not a BagIt bag (no bagit.txt).", dir)
+	}
+
+	for _, alg := range []string{"sha1", "sha256", "sha512", "md5"} {
+		mpath := filepath.Join(dir, "manifest-"+alg+".txt")
+		if _, err := os.Stat(mpath); err != nil {
+			continue
+		}
+
+		l, err := readChecksumLines(mpath, func(line string) (string, string) {
+			parts := strings.Fields(line)
+			if len(parts) < 2 {
+				return "", ""
+			}
+
+			// path is the rest of the line, percent-encoded.
+			p := strings.TrimSpace(line[len(parts[0]):])
+			return parts[0], bagDecodePath(p)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if l.Algorithm != alg {
+			return nil, fmt.Errorf("%s: checksums are not %s.", mpath, alg)
+		}
+		return l, l.rebase(dir)
+	}
+
+	return nil, fmt.Errorf("%s: no supported payload manifest found.", dir)
+}
+
+func readChecksumLines(path string, parse func(string) (string, string)) (*ChecksumList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &ChecksumList{Sums: map[string]string{}}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, p := parse(line)
+		alg := checksumAlgorithm(sum)
+		if len(p) == 0 || len(alg) == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid checksum line.", path, n)
+		}
+
+		if len(l.Algorithm) == 0 {
+			l.Algorithm = alg
+		} else if l.Algorithm != alg {
+			return nil, fmt.Errorf("%s:%d: mixed checksum algorithms.", path, n)
+		}
+
+		l.Sums[filepath.ToSlash(p)] = strings.ToLower(sum)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(l.Sums) == 0 {
+		return nil, fmt.Errorf("%s: no checksums found.", path)
+	}
+	return l, nil
+}
+
+// Makes paths (relative to dir) relative to the working directory.
+func (l *ChecksumList) rebase(dir string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	sums := map[string]string{}
+	for p, sum := range l.Sums {
+		rp, err := filepath.Rel(cwd, filepath.Join(dir, p))
+		if err != nil {
+			return err
+		}
+
+		if filepath.IsAbs(p) {
+			return fmt.Errorf("%s is outside the dataset directory.", p)
+		}
+
+		rp, err = NormalizePath(rp)
+		if err != nil {
+			return fmt.Errorf("%s is outside the dataset directory.", p)
+		}
+		sums[rp] = sum
+	}
+
+	l.Sums = sums
+	return nil
+}
+
+func (l *ChecksumList) Paths() []string {
+	paths := []string{}
+	for p, _ := range l.Sums {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Verifies files match their checksums. Returns the number that failed.
+func (l *ChecksumList) Verify() int {
+	failed := 0
+	for _, p := range l.Paths() {
+		sum, err := fileChecksum(p, checksumAlgorithms[l.Algorithm])
+		switch {
+		case err != nil:
+			pErr("data manifest: import %s FAIL - %s\n", p, err)
+			failed++
+		case sum != l.Sums[p]:
+			pErr("data manifest: import %s FAIL - %s mismatch\n", p, l.Algorithm)
+			failed++
+		default:
+			dErr("data manifest: import %s PASS\n", p)
+		}
+	}
+	return failed
+}
+
+func fileChecksum(path string, newHash func() hash.Hash) (string, error) {
+	f, err := os.Open(localPath(path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Imports the files in l into the manifest. sha1 checksums are used as
+// they are; files with other checksums are hashed.
+func (mf *Manifest) Import(l *ChecksumList, verify bool) error {
+	paths := l.Paths()
+
+	if verify {
+		if failed := l.Verify(); failed > 0 {
+			return fmt.Errorf("data manifest import: %d/%d checksums failed.",
+				failed, len(paths))
+		}
+		pErr("data manifest: verified %d %s checksums.\n", len(paths),
+			l.Algorithm)
+	}
+
+	if l.Algorithm != "sha1" {
+		if err := mf.AddPaths(paths); err != nil {
+			return err
+		}
+		return mf.HashPaths(paths)
+	}
+
+	for _, p := range paths {
+		mf.Files[p] = l.Sums[p]
+		if info, err := os.Stat(localPath(p)); err == nil {
+			mf.Meta[p] = NewFileMeta(p, info)
+		}
+		pErr("data manifest: imported %.7s %s\n", l.Sums[p], p)
+	}
+
+	return mf.WriteFile()
+}
+
+// Writes the manifest in sha1sum format.
+func (mf *Manifest) WriteChecksumList(w io.Writer) error {
+	paths := mf.AllPaths()
+	sort.Strings(paths)
+	for _, p := range paths {
+		if strings.ContainsAny(p, "\\\n") {
+			return fmt.Errorf("data manifest: cannot export path %q.", p)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s  %s\n", mf.Files[p], p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates a BagIt bag at dir with the manifest's files as payload.
+func (mf *Manifest) WriteBag(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("data manifest: %s already exists.", dir)
+	}
+
+	// payload
+	var size int64
+	paths := mf.AllPaths()
+	sort.Strings(paths)
+	for _, p := range paths {
+		dst := filepath.Join(dir, "data", p)
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return err
+		}
+
+		if err := os.Link(localPath(p), dst); err != nil {
+			if err := copyFile(localPath(p), dst); err != nil {
+				return fmt.Errorf("data manifest: copying %s: %s", p, err)
+			}
+		}
+
+		info, err := os.Stat(dst)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+	}
+
+	for p, _ := range mf.Dirs {
+		if err := os.MkdirAll(filepath.Join(dir, "data", p), 0777); err != nil {
+			return err
+		}
+	}
+
+	if len(mf.Links) > 0 {
+		pErr("Warning: symlinks are not exported to bags (%d skipped).\n",
+			len(mf.Links))
+	}
+
+	manifest := ""
+	for _, p := range paths {
+		manifest += fmt.Sprintf("%s  %s\n", mf.Files[p],
+			bagEncodePath("data/"+p))
+	}
+
+	tags := []struct{ name, contents string }{
+		{"bagit.txt", "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"},
+		{"bag-info.txt", fmt.Sprintf("Bagging-Date: %s\nPayload-Oxum: %d.%d\n",
+			time.Now().Format("2006-01-02"), size, len(paths))},
+		{"manifest-sha1.txt", manifest},
+	}
+
+	tagmanifest := ""
+	for _, t := range tags {
+		f, err := createFile(filepath.Join(dir, t.name))
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString(t.contents)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		sum, _ := StringHash(t.contents)
+		tagmanifest += fmt.Sprintf("%s  %s\n", sum, t.name)
+	}
+
+	f, err := createFile(filepath.Join(dir, "tagmanifest-sha1.txt"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(tagmanifest); err != nil {
+		return err
+	}
+
+	pErr("data manifest: exported %d files (%s) to bag %s\n", len(paths),
+		humanBytes(size), dir)
+	return nil
+}
+
+// BagIt manifests percent-encode CR, LF, and %.
+var bagPathEncoder = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D")
+var bagPathDecoder = strings.NewReplacer("%25", "%", "%0A", "\n", "%0a", "\n",
+	"%0D", "\r", "%0d", "\r")
+
+func bagEncodePath(p string) string {
+	return bagPathEncoder.Replace(p)
+}
+
+func bagDecodePath(p string) string {
+	return bagPathDecoder.Replace(p)
+}
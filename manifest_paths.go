@@ -0,0 +1,318 @@
+package data
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Manifest paths are portable: relative, '/'-separated, clean, and
+// Unicode NFC-normalized, regardless of how the local filesystem reports
+// them. Datasets must also install on case-insensitive filesystems, so
+// paths that differ only by case collide.
+
+// Returns the portable (manifest) form of local path p.
+func NormalizePath(p string) (string, error) {
+	if len(p) == 0 {
+		return "", fmt.Errorf("empty path")
+	}
+
+	n := filepath.ToSlash(p)
+	if filepath.IsAbs(p) || len(filepath.VolumeName(p)) > 0 ||
+		strings.HasPrefix(n, "/") {
+		return "", fmt.Errorf("absolute path: %s", p)
+	}
+
+	n = norm.NFC.String(n)
+	for _, part := range strings.Split(n, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path outside dataset (..): %s", p)
+		}
+	}
+
+	n = path.Clean(n)
+	if n == "." {
+		return "", fmt.Errorf("invalid path: %s", p)
+	}
+	return n, nil
+}
+
+// Returns the portable forms of paths.
+func normalizePaths(paths []string) ([]string, error) {
+	n := make([]string, len(paths))
+	for i, p := range paths {
+		var err error
+		n[i], err = NormalizePath(p)
+		if err != nil {
+			return nil, fmt.Errorf("data manifest: %s", err)
+		}
+	}
+	return n, nil
+}
+
+// Returns the tracked forms of paths: their portable forms, or, for files
+// tracked before paths were normalized (e.g. NFD names), the paths as
+// given, if tracked that way.
+func (mf *Manifest) trackedPaths(paths []string) ([]string, error) {
+	n := make([]string, len(paths))
+	for i, p := range paths {
+		var err error
+		n[i], err = NormalizePath(p)
+		if _, found := mf.Files[n[i]]; found && err == nil {
+			continue
+		}
+
+		if _, found := mf.Files[filepath.ToSlash(p)]; found {
+			n[i], err = filepath.ToSlash(p), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("data manifest: %s", err)
+		}
+	}
+	return n, nil
+}
+
+// Checks p is a manifest path (already in portable form).
+func validManifestPath(p string) error {
+	n, err := NormalizePath(p)
+	if err != nil {
+		return err
+	}
+
+	if n != p {
+		return fmt.Errorf("path not normalized: %q (should be %q)", p, n)
+	}
+	return nil
+}
+
+// Returns the local path for manifest path p. Some filesystems store
+// names decomposed (NFD), so if p is not found, its NFD form is tried.
+func localPath(p string) string {
+	lp := filepath.FromSlash(p)
+	if _, err := os.Lstat(lp); err == nil {
+		return lp
+	}
+
+	if d := norm.NFD.String(lp); d != lp {
+		if _, err := os.Lstat(d); err == nil {
+			return d
+		}
+	}
+	return lp
+}
+
+// Paths that fold to the same string collide on case-insensitive (and
+// normalization-insensitive) filesystems.
+func foldPath(p string) string {
+	return norm.NFC.String(strings.ToLower(norm.NFD.String(p)))
+}
+
+// Checks all manifest paths are portable, that no files are stored
+// beneath symlinks, and that symlinks do not point outside the dataset.
+func (mf *Manifest) ValidatePaths() error {
+	bad := mf.InvalidPaths()
+	if len(bad) > 0 {
+		return fmt.Errorf(InvalidPathsMsg, strings.Join(bad, "\n    "))
+	}
+	return nil
+}
+
+// Returns descriptions of all invalid manifest paths (see ValidatePaths),
+// sorted.
+func (mf *Manifest) InvalidPaths() []string {
+	bad := []string{}
+	check := func(p string) {
+		if err := validManifestPath(p); err != nil {
+			bad = append(bad, err.Error())
+			return
+		}
+
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			if _, found := mf.Links[d]; found {
+				bad = append(bad, fmt.Sprintf("path beneath symlink: %s", p))
+				return
+			}
+		}
+	}
+
+	for p, _ := range mf.Files {
+		check(p)
+	}
+	for p, _ := range mf.Dirs {
+		check(p)
+	}
+	for p, t := range mf.Links {
+		check(p)
+		if linkEscapes(p, t, mf.Links) {
+			bad = append(bad, fmt.Sprintf("symlink points outside the "+
+				"dataset: %s -> %s", p, t))
+		}
+	}
+
+	sort.Strings(bad)
+	return bad
+}
+
+// Whether symlink p, with target t, points outside the dataset (absolute,
+// or above the dataset directory), following the dataset's other links
+// { path : target } as the filesystem would.
+func linkEscapes(p string, t string, links map[string]string) bool {
+	if absLinkTarget(t) {
+		return true
+	}
+
+	// (not path.Join: ".." must follow links, not drop them.)
+	_, ok := resolveLinks(path.Dir(p)+"/"+t, links)
+	return !ok
+}
+
+// Returns the symlink among links that points outside the dataset, if any
+// (e.g. once a new link is added to them).
+func escapingLink(links map[string]string) string {
+	for _, p := range sortedLinks(links) {
+		if linkEscapes(p, links[p], links) {
+			return p
+		}
+	}
+	return ""
+}
+
+func sortedLinks(links map[string]string) []string {
+	paths := []string{}
+	for p, _ := range links {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func absLinkTarget(t string) bool {
+	return len(t) == 0 || path.IsAbs(t) || strings.HasPrefix(t, "\\") ||
+		(len(t) > 1 && t[1] == ':')
+}
+
+// Links followed resolving one path, at most (as with the OS, loops fail).
+const maxLinkHops = 40
+
+// Resolves dataset path p through links { path : target }, one component
+// at a time. Returns false if p leads outside the dataset (or loops).
+func resolveLinks(p string, links map[string]string) (string, bool) {
+	pending := strings.Split(strings.Replace(p, "\\", "/", -1), "/")
+	resolved := []string{}
+	hops := 0
+
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", false
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		t, found := links[strings.Join(append(resolved, c), "/")]
+		if !found {
+			resolved = append(resolved, c)
+			continue
+		}
+
+		hops++
+		if hops > maxLinkHops || absLinkTarget(t) {
+			return "", false
+		}
+
+		// the target replaces c (relative to its directory).
+		t = strings.Replace(t, "\\", "/", -1)
+		pending = append(strings.Split(t, "/"), pending...)
+	}
+	return strings.Join(resolved, "/"), true
+}
+
+// Returns groups of manifest paths (including parent directories) that
+// collide on case-insensitive filesystems.
+func (mf *Manifest) PathCollisions() [][]string {
+	folded := map[string]map[string]bool{}
+	add := func(p string) {
+		for d := p; d != "." && d != "/"; d = path.Dir(d) {
+			k := foldPath(d)
+			if folded[k] == nil {
+				folded[k] = map[string]bool{}
+			}
+			folded[k][d] = true
+		}
+	}
+
+	for p, _ := range mf.Files {
+		add(p)
+	}
+	for p, _ := range mf.Dirs {
+		add(p)
+	}
+	for p, _ := range mf.Links {
+		add(p)
+	}
+
+	groups := [][]string{}
+	for _, paths := range folded {
+		if len(paths) < 2 {
+			continue
+		}
+
+		g := []string{}
+		for p, _ := range paths {
+			g = append(g, p)
+		}
+		sort.Strings(g)
+		groups = append(groups, g)
+	}
+
+	sort.Sort(pathGroups(groups))
+	return groups
+}
+
+type pathGroups [][]string
+
+func (g pathGroups) Len() int           { return len(g) }
+func (g pathGroups) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g pathGroups) Less(i, j int) bool { return g[i][0] < g[j][0] }
+
+// Checks the manifest installs correctly on any filesystem: paths are
+// portable, and do not collide.
+func (mf *Manifest) CheckPortable() error {
+	if err := mf.ValidatePaths(); err != nil {
+		return err
+	}
+
+	groups := mf.PathCollisions()
+	if len(groups) == 0 {
+		return nil
+	}
+
+	lines := []string{}
+	for _, g := range groups {
+		lines = append(lines, strings.Join(g, "  "))
+	}
+	return fmt.Errorf(PathCollisionsMsg, len(groups),
+		strings.Join(lines, "\n    "))
+}
+
+const InvalidPathsMsg = `Manifest has invalid paths:
+    %s
+Manifest paths (and symlink targets) must be relative, and within the
+dataset directory. (Use --symlinks follow to store linked files instead.)`
+
+const PathCollisionsMsg = `Manifest has %d path collisions:
+    %s
+These paths differ only by case (or unicode normalization), and would
+collide on case-insensitive filesystems (e.g. on Mac OS X and Windows).
+Please rename them.`
@@ -0,0 +1,215 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// "é" composed (NFC), and decomposed (NFD).
+const nfcE = "\u00e9"
+const nfdE = "e\u0301"
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string // "" if invalid
+	}{
+		{"a.txt", "a.txt"},
+		{"./a//b/", "a/b"},
+		{"a/./b", "a/b"},
+		{"caf" + nfdE, "caf" + nfcE},
+		{"caf" + nfcE + "/" + nfdE, "caf" + nfcE + "/" + nfcE},
+		{"", ""},
+		{".", ""},
+		{"/a", ""},
+		{"../a", ""},
+		{"a/../../b", ""},
+		{"a/..", ""},
+	}
+
+	for _, tt := range tests {
+		n, err := NormalizePath(tt.path)
+		if len(tt.expected) == 0 {
+			if err == nil {
+				t.Errorf("%q: normalized to %q, expected error", tt.path, n)
+			}
+			continue
+		}
+
+		if err != nil || n != tt.expected {
+			t.Errorf("%q: normalized to %q (%v), expected %q", tt.path, n,
+				err, tt.expected)
+		}
+		if err := validManifestPath(n); err != nil {
+			t.Errorf("%q: %s", n, err)
+		}
+	}
+
+	if err := validManifestPath("caf" + nfdE); err == nil {
+		t.Error("NFD path valid")
+	}
+}
+
+func TestPathCollisions(t *testing.T) {
+	mf := NewManifest("")
+	for _, p := range []string{"a.txt", "A.txt", "b/c", "B/d", "caf" + nfcE,
+		"CAF" + nfdE, "x"} {
+		mf.Files[p] = noHash
+	}
+
+	expected := [][]string{
+		{"A.txt", "a.txt"},
+		{"B", "b"},
+		{"CAF" + nfdE, "caf" + nfcE},
+	}
+	if g := mf.PathCollisions(); !reflect.DeepEqual(g, expected) {
+		t.Errorf("collisions %q, expected %q", g, expected)
+	}
+	if err := mf.CheckPortable(); err == nil {
+		t.Error("colliding manifest portable")
+	}
+}
+
+func TestLinkEscapes(t *testing.T) {
+	tests := []struct {
+		path    string
+		target  string
+		escapes bool
+	}{
+		{"l", "a.txt", false},
+		{"d/l", "../a.txt", false},
+		{"d/e/l", "../../a", false},
+		{"l", "d/../a", false},
+		{"l", "", true},
+		{"l", "/etc/passwd", true},
+		{"l", "\\\\host\\share", true},
+		{"l", "C:\\x", true},
+		{"l", "..", true},
+		{"l", "../a", true},
+		{"d/l", "../../a", true},
+		{"d/l", "..\\..\\a", true},
+		{"l", "a/../../b", true},
+	}
+
+	for _, tt := range tests {
+		if linkEscapes(tt.path, tt.target, nil) != tt.escapes {
+			t.Errorf("%s -> %s: escapes %v", tt.path, tt.target, !tt.escapes)
+		}
+	}
+
+	// links are followed: each of these looks contained on its own.
+	links := map[string]string{
+		"d1/d2/b": "../..",
+		"a":       "d1/d2/b/..",
+		"c":       "d1/d2/b",
+		"loop":    "loop/x",
+	}
+	for p, escapes := range map[string]bool{"d1/d2/b": false, "a": true,
+		"c": false, "loop": true} {
+		if linkEscapes(p, links[p], links) != escapes {
+			t.Errorf("%s -> %s: escapes %v", p, links[p], !escapes)
+		}
+	}
+
+	if e := escapingLink(links); e != "a" {
+		t.Errorf("escaping link: %q", e)
+	}
+}
+
+func TestRemovePaths(t *testing.T) {
+	defer testDatasetDir(t)()
+
+	mf := NewManifest(ManifestFileName)
+	mf.Files["caf"+nfcE] = noHash
+	mf.Files["legacy-"+nfdE] = noHash // tracked before normalization.
+	mf.Files["a/b"] = noHash
+	mf.Files["c"] = noHash
+
+	err := mf.RemovePaths([]string{"caf" + nfdE, "legacy-" + nfdE, "./a/b",
+		"nothere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := blobPaths{"c": noHash}
+	if !reflect.DeepEqual(mf.Files, expected) {
+		t.Errorf("removed, left %q, expected %q", mf.Files, expected)
+	}
+
+	if err := mf.RemovePaths([]string{"c", "../x"}); err == nil {
+		t.Error("removed invalid path")
+	}
+	if _, found := mf.Files["c"]; !found {
+		t.Error("removed paths before an invalid one")
+	}
+}
+
+func TestNormalizationCollision(t *testing.T) {
+	defer testDatasetDir(t, "d/caf"+nfcE, "d/caf"+nfdE)()
+
+	_, err := listAllEntries(".", SymlinksFollow)
+	if err == nil {
+		t.Fatal("normalization collision not detected")
+	}
+
+	expected := fmt.Sprintf("as %q", "d/caf"+nfcE)
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("error %q, expected %s", err, expected)
+	}
+
+	// (no collision once one is renamed)
+	if err := os.Rename("d/caf"+nfdE, "d/other"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := listAllEntries(".", SymlinksFollow)
+	if err != nil || len(l.Files) != 2 {
+		t.Errorf("listed %v (%v)", l, err)
+	}
+}
+
+func TestHashedPaths(t *testing.T) {
+	mf := NewManifest("")
+	mf.Files["a.txt"] = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	mf.Files["b.txt"] = noHash
+	mf.Files["c.txt"] = "7c211433f02071597741e6ff5a8ea34789abbf43"
+
+	hashed := mf.HashedPaths()
+	sort.Strings(hashed)
+	if strings.Join(hashed, " ") != "a.txt c.txt" {
+		t.Errorf("hashed: %v", hashed)
+	}
+
+	if unhashed := mf.UnhashedPaths(); strings.Join(unhashed, " ") != "b.txt" {
+		t.Errorf("unhashed: %v", unhashed)
+	}
+}
+
+func TestRestoreMetaOutput(t *testing.T) {
+	root, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	mf := NewManifest(path.Join(root, ManifestFileName))
+	mf.Links["d/l"] = "../a.txt"
+	out := &bytes.Buffer{}
+	if err := mf.RestoreMeta(nil, out); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := os.Readlink(path.Join(root, "d/l"))
+	if target != "../a.txt" {
+		t.Errorf("link not restored: %q (%v)", target, err)
+	}
+	if out.String() != "link d/l -> ../a.txt\n" {
+		t.Errorf("output: %q", out.String())
+	}
+}
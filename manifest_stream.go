@@ -0,0 +1,1129 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Large manifests.
+//
+// Manifests with many entries can be stored (with --format stream) in a
+// line-oriented format rather than YAML: a header line, then one entry
+// per line, sorted by path:
+//
+//	#data-manifest stream 1 merkle=<bool> symlinks=<policy>
+//	<path>\t<kind>\t<hash>\t<size>\t<mode>\t<type>\t<target>
+//
+// (tabs, newlines, and backslashes in paths are escaped.) This way,
+// generating, checking, and listing blobs of large manifests streams
+// through them, rather than loading every entry into memory.
+//
+// Lookups by path or hash use an index (ManifestFileName + ".index"),
+// which is derived from the manifest, and rebuilt whenever it changes.
+// While hashing, updated entries are appended to a journal
+// (ManifestFileName + ".journal"), merged into the manifest once done.
+// Indexes and journals are never published.
+
+// Manifest formats (see manifestFormat). The format is recorded in the
+// manifest itself (Manifest.Stream), and only changes when asked to, as
+// it changes the manifest's hash.
+const (
+	ManifestFormatYAML   = "yaml"
+	ManifestFormatStream = "stream"
+)
+
+// Generating YAML manifests with at least this many entries suggests
+// streams instead.
+var ManifestStreamThreshold = 50000
+
+const manifestStreamMagic = "#data-manifest stream 1"
+
+// Every manifestIndexInterval-th entry is indexed by path.
+const manifestIndexInterval = 64
+
+// Number of entries hashed (or checked) at a time when streaming.
+const manifestStreamBatch = 1024
+
+// One manifest entry: a file, an empty directory, or a symlink.
+type ManifestRecord struct {
+	Kind   string // file, dir, or link
+	Path   string
+	Hash   string
+	Size   int64
+	Mode   string
+	Type   string
+	Target string
+}
+
+func (r *ManifestRecord) FileMeta() *FileMeta {
+	e := &manifestEntry{Size: r.Size, Mode: r.Mode, Type: r.Type}
+	return e.FileMeta()
+}
+
+// Returns the manifest entries as records, sorted by path.
+func (mf *Manifest) Records() []*ManifestRecord {
+	records := []*ManifestRecord{}
+
+	for p, h := range mf.Files {
+		r := &ManifestRecord{Kind: "file", Path: p, Hash: h}
+		if m, found := mf.Meta[p]; found {
+			e := newManifestEntry(h, m)
+			r.Size, r.Mode, r.Type = e.Size, e.Mode, e.Type
+		}
+		records = append(records, r)
+	}
+
+	for p, m := range mf.Dirs {
+		e := newManifestEntry("", m)
+		records = append(records, &ManifestRecord{Kind: "dir", Path: p,
+			Mode: e.Mode})
+	}
+
+	for p, t := range mf.Links {
+		records = append(records, &ManifestRecord{Kind: "link", Path: p,
+			Target: t})
+	}
+
+	sort.Sort(manifestRecords(records))
+	return records
+}
+
+// Adds record to the manifest.
+func (mf *Manifest) addRecord(r *ManifestRecord) {
+	switch r.Kind {
+	case "file":
+		mf.Files[r.Path] = r.Hash
+
+		// (files added but never hashed have no metadata)
+		if len(r.Mode) > 0 {
+			mf.Meta[r.Path] = r.FileMeta()
+		}
+	case "dir":
+		mf.Dirs[r.Path] = r.FileMeta()
+	case "link":
+		mf.Links[r.Path] = r.Target
+	}
+}
+
+type manifestRecords []*ManifestRecord
+
+func (l manifestRecords) Len() int           { return len(l) }
+func (l manifestRecords) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l manifestRecords) Less(i, j int) bool { return recordLess(l[i], l[j]) }
+
+// Records are ordered by path, then kind.
+func recordLess(a, b *ManifestRecord) bool {
+	if a.Path == b.Path {
+		return a.Kind < b.Kind
+	}
+	return a.Path < b.Path
+}
+
+var recordEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n")
+var recordUnescaper = strings.NewReplacer("\\\\", "\\", "\\t", "\t", "\\n", "\n")
+
+func (r *ManifestRecord) line() string {
+	fields := []string{
+		recordEscaper.Replace(r.Path),
+		r.Kind,
+		r.Hash,
+		strconv.FormatInt(r.Size, 10),
+		r.Mode,
+		recordEscaper.Replace(r.Type),
+		recordEscaper.Replace(r.Target),
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+func parseManifestRecord(line string) (*ManifestRecord, error) {
+	f := strings.Split(strings.TrimRight(line, "\n"), "\t")
+	if len(f) != 7 {
+		return nil, fmt.Errorf("invalid manifest entry: %q", line)
+	}
+
+	size, err := strconv.ParseInt(f[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest entry size: %q", line)
+	}
+
+	return &ManifestRecord{
+		Path:   recordUnescaper.Replace(f[0]),
+		Kind:   f[1],
+		Hash:   f[2],
+		Size:   size,
+		Mode:   f[4],
+		Type:   recordUnescaper.Replace(f[5]),
+		Target: recordUnescaper.Replace(f[6]),
+	}, nil
+}
+
+func manifestStreamHeader(merkle bool, symlinks string) string {
+	return fmt.Sprintf("%s merkle=%t symlinks=%s\n", manifestStreamMagic,
+		merkle, symlinks)
+}
+
+// Returns the manifest in stream format.
+func (mf *Manifest) marshalStream() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(manifestStreamHeader(mf.Merkle, mf.Symlinks))
+	for _, r := range mf.Records() {
+		buf.WriteString(r.line())
+	}
+	return buf.Bytes(), nil
+}
+
+// Reads the manifest from stream format.
+func (mf *Manifest) unmarshalStream(buf []byte) error {
+	r := newManifestStreamReader(bytes.NewReader(buf))
+	s, err := r.header("")
+	if err != nil {
+		return err
+	}
+
+	mf.Merkle = s.Merkle
+	mf.Symlinks = s.Symlinks
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return nil
+		}
+		mf.addRecord(rec)
+	}
+}
+
+func isManifestStream(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte(manifestStreamMagic))
+}
+
+// A manifest file in stream format.
+type ManifestStream struct {
+	Path     string
+	Merkle   bool
+	Symlinks string
+}
+
+// Opens the manifest at path as a stream. Returns nil if the manifest is
+// not in stream format (or does not exist).
+func OpenManifestStream(path string) (*ManifestStream, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(manifestStreamMagic))
+	if _, err := io.ReadFull(f, buf); err != nil || !isManifestStream(buf) {
+		return nil, nil
+	}
+
+	f.Seek(0, 0)
+	return newManifestStreamReader(f).header(path)
+}
+
+func (s *ManifestStream) SymlinkPolicy() string {
+	if len(s.Symlinks) == 0 {
+		return SymlinksFollow
+	}
+	return s.Symlinks
+}
+
+// Returns the directory of the dataset the stream describes (see
+// Manifest.root).
+func (s *ManifestStream) root() string {
+	return manifestRoot(s.Path)
+}
+
+// Calls fn with every entry, in order.
+func (s *ManifestStream) Each(fn func(r *ManifestRecord) error) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newManifestStreamReader(f)
+	if _, err := r.header(s.Path); err != nil {
+		return err
+	}
+
+	for {
+		rec, err := r.Next()
+		if err != nil || rec == nil {
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns the blobs (files with hashes) in the manifest.
+func (s *ManifestStream) BlobPaths() (blobPaths, error) {
+	blobs := blobPaths{}
+	err := s.Each(func(r *ManifestRecord) error {
+		if r.Kind == "file" && IsHash(r.Hash) {
+			blobs[r.Path] = r.Hash
+		}
+		return nil
+	})
+	return blobs, err
+}
+
+// Returns the entry for path (nil if not found), using the index.
+func (s *ManifestStream) Lookup(path string) (*ManifestRecord, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
+	if idx.paths == 0 {
+		return nil, nil
+	}
+
+	// find the last indexed entry before path.
+	var ferr error
+	i := sort.Search(int(idx.paths), func(i int) bool {
+		r, err := idx.recordAt(idx.pathOffset(i))
+		if err != nil {
+			ferr = err
+			return true
+		}
+		return r.Path >= path
+	})
+	if ferr != nil {
+		return nil, ferr
+	}
+	if i > 0 {
+		i--
+	}
+
+	// scan from there.
+	offset := idx.pathOffset(i)
+	r := newManifestStreamReader(io.NewSectionReader(idx.mf, offset,
+		idx.size-offset))
+	for {
+		rec, err := r.Next()
+		if err != nil || rec == nil || rec.Path > path {
+			return nil, err
+		}
+
+		if rec.Path == path {
+			return rec, nil
+		}
+	}
+}
+
+// Returns the paths of files with hash, using the index.
+func (s *ManifestStream) PathsForHash(hash string) ([]string, error) {
+	idx, err := s.index()
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
+	paths := []string{}
+	i := sort.Search(int(idx.hashes), func(i int) bool {
+		h, _ := idx.hashEntry(i)
+		return h >= hash
+	})
+
+	for ; i < int(idx.hashes); i++ {
+		h, off := idx.hashEntry(i)
+		if h != hash {
+			break
+		}
+
+		r, err := idx.recordAt(off)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, r.Path)
+	}
+	return paths, nil
+}
+
+// Checks all files, in batches, verifying their checksums match the
+// manifest. Returns the number of files checked, and those that failed.
+func (s *ManifestStream) Check() (int, int, error) {
+	total, failed, err := s.CheckFailures()
+	return total, len(failed), err
+}
+
+// Checks all files, as Check, returning the files that failed
+// { path : hash }.
+func (s *ManifestStream) CheckFailures() (int, blobPaths, error) {
+	total, failed := 0, blobPaths{}
+	stats := newHashStats()
+	batch := blobPaths{}
+
+	check := func() {
+		for p, h := range checkFiles(s.root(), batch, stats) {
+			failed[p] = h
+		}
+		batch = blobPaths{}
+	}
+
+	err := s.Each(func(r *ManifestRecord) error {
+		if r.Kind != "file" {
+			return nil
+		}
+
+		total++
+		batch[r.Path] = r.Hash
+		if len(batch) >= manifestStreamBatch {
+			check()
+		}
+		return nil
+	})
+	if err != nil {
+		return total, failed, err
+	}
+	check()
+
+	if stats.Files > 1 {
+		pErr("data manifest: checked %s\n", stats)
+	}
+	return total, failed, nil
+}
+
+// Generates (or patches) the manifest, streaming through it: the sorted
+// directory listing is merged with the manifest as it is walked, and new
+// files hashed.
+func (s *ManifestStream) Generate() error {
+	pErr("Generating Manifest file...\n")
+
+	// finish hashing interrupted earlier.
+	if err := s.applyJournal(); err != nil {
+		return err
+	}
+
+	s.Symlinks = s.SymlinkPolicy()
+	listed := newListingFeed(s.root(), s.Symlinks)
+	defer listed.Close()
+
+	// first pass: merge listing into manifest.
+	links := map[string]bool{}
+	missing := []string{}
+	unhashed := 0
+	err := s.rewrite(func(old *ManifestRecord, write func(*ManifestRecord) error) error {
+		// write all listed entries before old.
+		for {
+			r, err := listed.Peek()
+			if err != nil {
+				return err
+			}
+			if r == nil || (old != nil && !recordLess(r, old)) {
+				break
+			}
+			listed.Pop()
+
+			switch r.Kind {
+			case "file":
+				pErr("data manifest: added %s\n", r.Path)
+				unhashed++
+			case "link":
+				links[r.Path] = true
+			}
+			if err := write(r); err != nil {
+				return err
+			}
+		}
+
+		// dirs and links are replaced by those listed.
+		if old == nil || old.Kind != "file" {
+			return nil
+		}
+
+		// the same file is listed: keep the old entry.
+		r, err := listed.Peek()
+		if err != nil {
+			return err
+		}
+		if r != nil && r.Path == old.Path && r.Kind == old.Kind {
+			listed.Pop()
+		}
+
+		// files now stored as links (or beneath them) are no longer tracked.
+		linked := r != nil && r.Path == old.Path && r.Kind == "link"
+		for d := path.Dir(old.Path); !linked && d != "." && d != "/"; d = path.Dir(d) {
+			linked = links[d]
+		}
+		if linked {
+			pErr("data manifest: removed %s\n", old.Path)
+			return nil
+		}
+
+		lp := localPath(path.Join(s.root(), old.Path))
+		if _, err := os.Lstat(lp); os.IsNotExist(err) {
+			missing = append(missing, old.Path)
+		} else if !IsHash(old.Hash) || len(old.Mode) == 0 {
+			unhashed++
+		}
+		return write(old)
+	})
+	if err != nil {
+		return err
+	}
+
+	// warn about manifest-listed files missing from directory
+	if len(missing) > 0 {
+		pErr("Warning: %d files in Manifest missing from directory:\n",
+			len(missing))
+		for _, f := range missing {
+			pErr("    %s\n", f)
+		}
+		pErr(ManifestMissingMsg)
+	}
+
+	// second pass: hash all unhashed files (and record missing metadata).
+	if unhashed > 0 {
+		if err := s.hashFiles(); err != nil {
+			return err
+		}
+	}
+
+	count := 0
+	var size int64
+	err = s.Each(func(r *ManifestRecord) error {
+		if r.Kind == "file" {
+			count++
+			size += r.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		pErr("Warning: no files in directory. Manifest is empty.\n")
+	} else {
+		pErr("%d files (%s) in Manifest.\n", count, humanBytes(size))
+	}
+	return nil
+}
+
+// Directory listing entries (see walkEntries), walked in the background
+// and read in order.
+type listingFeed struct {
+	c    chan *ManifestRecord
+	stop chan bool
+	next *ManifestRecord
+	err  error // set before c is closed
+}
+
+var errListingStopped = fmt.Errorf("listing stopped")
+
+func newListingFeed(root string, symlinks string) *listingFeed {
+	f := &listingFeed{
+		c:    make(chan *ManifestRecord, manifestStreamBatch),
+		stop: make(chan bool),
+	}
+
+	go func() {
+		f.err = walkEntries(root, symlinks, func(r *ManifestRecord) error {
+			select {
+			case f.c <- r:
+				return nil
+			case <-f.stop:
+				return errListingStopped
+			}
+		})
+		close(f.c)
+	}()
+	return f
+}
+
+// Returns the next entry, without consuming it, or nil at the end (with
+// the error that ended the walk, if any).
+func (f *listingFeed) Peek() (*ManifestRecord, error) {
+	if f.next == nil {
+		r, ok := <-f.c
+		if !ok {
+			return nil, f.err
+		}
+		f.next = r
+	}
+	return f.next, nil
+}
+
+// Consumes the next entry.
+func (f *listingFeed) Pop() {
+	f.next = nil
+}
+
+// Stops the walk (if still walking).
+func (f *listingFeed) Close() {
+	close(f.stop)
+	for _ = range f.c {
+	}
+}
+
+// Hashes all unhashed files, in parallel batches. Updated entries are
+// appended to the journal, which is synced periodically (see
+// ManifestCheckpointInterval) so that progress survives interruption,
+// and merged into the manifest at the end (see applyJournal).
+func (s *ManifestStream) hashFiles() error {
+	if err := s.applyJournal(); err != nil {
+		return err
+	}
+
+	j, err := createFile(s.JournalPath())
+	if err != nil {
+		return err
+	}
+	jw := bufio.NewWriter(j)
+
+	stats := newHashStats()
+	checkpoint := time.Now()
+	window := []*ManifestRecord{}
+
+	// hashes the files in window that need it, and journals them.
+	flush := func() error {
+		paths := []string{}
+		updated := map[string]*ManifestRecord{}
+		for _, r := range window {
+			info, err := os.Stat(localPath(path.Join(s.root(), r.Path)))
+			switch {
+			case err != nil:
+				// missing files cannot be hashed, skip them.
+			case !IsHash(r.Hash):
+				paths = append(paths, r.Path)
+			case len(r.Mode) == 0:
+				// record metadata of files hashed before it was tracked.
+				e := newManifestEntry(r.Hash, NewFileMeta(r.Path, info))
+				r.Size, r.Mode, r.Type = e.Size, e.Mode, e.Type
+				updated[r.Path] = r
+			}
+		}
+
+		var herr error
+		for res := range hashFiles(s.root(), paths, nil) {
+			if res.Err != nil {
+				if herr == nil {
+					herr = res.Err
+				}
+				continue
+			}
+
+			e := newManifestEntry(res.Hash, NewFileMeta(res.Path, res.Info))
+			updated[res.Path] = &ManifestRecord{Kind: "file", Path: res.Path,
+				Hash: e.Hash, Size: e.Size, Mode: e.Mode, Type: e.Type}
+			stats.Add(res)
+			pErr("data manifest: hashed %.7s %s\n", res.Hash, res.Path)
+		}
+
+		// journal in manifest order.
+		for _, r := range window {
+			if u, found := updated[r.Path]; found {
+				if _, err := jw.WriteString(u.line()); err != nil {
+					return err
+				}
+			}
+		}
+		window = window[:0]
+
+		if time.Since(checkpoint) > ManifestCheckpointInterval {
+			dErr("data manifest: checkpoint (%s)\n", stats)
+			if err := jw.Flush(); err != nil {
+				return err
+			}
+			if err := j.Sync(); err != nil {
+				return err
+			}
+			checkpoint = time.Now()
+		}
+		return herr
+	}
+
+	err = s.Each(func(r *ManifestRecord) error {
+		if r.Kind != "file" {
+			return nil
+		}
+
+		window = append(window, r)
+		if len(window) < manifestStreamBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// save what was hashed.
+	if ferr := jw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := j.Close(); err == nil {
+		err = cerr
+	}
+	if jerr := s.applyJournal(); err == nil {
+		err = jerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if stats.Files > 1 {
+		pErr("data manifest: hashed %s\n", stats)
+	}
+	return nil
+}
+
+// The journal of entries updated since the manifest was last rewritten:
+// entry lines (as in the manifest), appended in order.
+func (s *ManifestStream) JournalPath() string {
+	return s.Path + ".journal"
+}
+
+// Merges the journal into the manifest (replacing the entries it
+// updates), in one rewrite, and removes it. A journal left by an
+// interrupted run is merged the same way: its last line, if incomplete,
+// is ignored.
+func (s *ManifestStream) applyJournal() error {
+	j, err := os.Open(s.JournalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	jr := bufio.NewReader(j)
+	var next *ManifestRecord
+	read := func() {
+		next = nil
+		line, err := jr.ReadString('\n')
+		if err != nil {
+			return // end (or incomplete line)
+		}
+
+		r, err := parseManifestRecord(line)
+		if err != nil {
+			dErr("data manifest: journal: %s\n", err)
+			return
+		}
+		next = r
+	}
+	read()
+
+	// (nothing updated)
+	if next == nil {
+		j.Close()
+		return os.Remove(s.JournalPath())
+	}
+
+	err = s.rewrite(func(old *ManifestRecord, write func(*ManifestRecord) error) error {
+		if old == nil {
+			return nil
+		}
+
+		for next != nil && recordLess(next, old) {
+			read() // (entry no longer in the manifest)
+		}
+
+		if next != nil && next.Path == old.Path && next.Kind == old.Kind {
+			old = next
+			read()
+		}
+		return write(old)
+	})
+	if err != nil {
+		return err
+	}
+
+	j.Close()
+	return os.Remove(s.JournalPath())
+}
+
+// Rewrites the manifest, calling fn with each old entry (in order), and
+// finally with nil. fn writes entries (in order) with write. The new
+// manifest replaces the old one once done, even if fn returns an error,
+// so that partial work is kept.
+func (s *ManifestStream) rewrite(fn func(old *ManifestRecord, write func(*ManifestRecord) error) error) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newManifestStreamReader(f)
+	if _, err := r.header(s.Path); err != nil {
+		return err
+	}
+
+	w, err := newManifestStreamWriter(s.Path, s.Merkle, s.Symlinks)
+	if err != nil {
+		return err
+	}
+
+	var ferr error
+	for {
+		old, err := r.Next()
+		if err != nil {
+			w.Abort()
+			return err
+		}
+
+		if ferr == nil {
+			ferr = fn(old, w.Write)
+		} else if old != nil {
+			// after an error, keep the remaining old entries.
+			if err := w.Write(old); err != nil {
+				w.Abort()
+				return err
+			}
+		}
+
+		if old == nil {
+			break
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// Reads a manifest stream.
+type manifestStreamReader struct {
+	r      *bufio.Reader
+	offset int64 // of the next line
+}
+
+func newManifestStreamReader(r io.Reader) *manifestStreamReader {
+	return &manifestStreamReader{r: bufio.NewReader(r)}
+}
+
+func (r *manifestStreamReader) line() (string, error) {
+	line, err := r.r.ReadString('\n')
+	r.offset += int64(len(line))
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return line, err
+}
+
+func (r *manifestStreamReader) header(path string) (*ManifestStream, error) {
+	line, err := r.line()
+	if err != nil || !isManifestStream([]byte(line)) {
+		return nil, fmt.Errorf("%s: invalid manifest stream header.", path)
+	}
+
+	s := &ManifestStream{Path: path}
+	for _, f := range strings.Fields(line[len(manifestStreamMagic):]) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) < 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "merkle":
+			s.Merkle = kv[1] == "true"
+		case "symlinks":
+			s.Symlinks = kv[1]
+		}
+	}
+	return s, nil
+}
+
+// Returns the next entry, or nil at the end.
+func (r *manifestStreamReader) Next() (*ManifestRecord, error) {
+	line, err := r.line()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseManifestRecord(line)
+}
+
+// Writes a manifest stream to a temporary file, which replaces the
+// manifest on Close. Entries must be written in order.
+type manifestStreamWriter struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	last *ManifestRecord
+}
+
+func newManifestStreamWriter(path string, merkle bool, symlinks string) (*manifestStreamWriter, error) {
+	f, err := createFile(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &manifestStreamWriter{path: path, f: f, w: bufio.NewWriter(f)}
+	if _, err := w.w.WriteString(manifestStreamHeader(merkle, symlinks)); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *manifestStreamWriter) Write(r *ManifestRecord) error {
+	if w.last != nil && !recordLess(w.last, r) {
+		return fmt.Errorf("data manifest: entries out of order: %s, %s",
+			w.last.Path, r.Path)
+	}
+
+	w.last = r
+	_, err := w.w.WriteString(r.line())
+	return err
+}
+
+func (w *manifestStreamWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	return os.Rename(w.f.Name(), w.path)
+}
+
+func (w *manifestStreamWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// Index of a manifest stream. The index file has a header line (with the
+// manifest's size and modification time, to detect changes, and section
+// lengths), then fixed-width lines, for binary search:
+//
+//	<offset>            every manifestIndexInterval-th entry
+//	<hash> <offset>     every file with a hash, sorted by hash
+type manifestIndex struct {
+	mf     *os.File // manifest
+	idx    *os.File
+	size   int64 // of manifest
+	paths  int64
+	hashes int64
+}
+
+const (
+	indexHeaderLen = 4 * 17
+	indexPathLen   = 17
+	indexHashLen   = 40 + 1 + 17
+)
+
+func (s *ManifestStream) IndexPath() string {
+	return s.Path + ".index"
+}
+
+// Opens the index, (re)building it if it is missing or out of date.
+func (s *ManifestStream) index() (*manifestIndex, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := s.openIndex(info)
+	if err == nil {
+		return idx, nil
+	}
+
+	dErr("data manifest: indexing (%s)\n", err)
+	if err := s.buildIndex(info); err != nil {
+		return nil, err
+	}
+	return s.openIndex(info)
+}
+
+func (s *ManifestStream) openIndex(info os.FileInfo) (*manifestIndex, error) {
+	f, err := os.Open(s.IndexPath())
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, indexHeaderLen)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var size, mtime, paths, hashes int64
+	_, err = fmt.Sscanf(string(header), "%016x %016x %016x %016x\n",
+		&size, &mtime, &paths, &hashes)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if size != info.Size() || mtime != info.ModTime().UnixNano() {
+		f.Close()
+		return nil, fmt.Errorf("index out of date")
+	}
+
+	mf, err := os.Open(s.Path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &manifestIndex{mf: mf, idx: f, size: size, paths: paths,
+		hashes: hashes}, nil
+}
+
+type hashOffset struct {
+	hash   string
+	offset int64
+}
+
+type hashOffsets []hashOffset
+
+func (l hashOffsets) Len() int      { return len(l) }
+func (l hashOffsets) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l hashOffsets) Less(i, j int) bool {
+	if l[i].hash == l[j].hash {
+		return l[i].offset < l[j].offset
+	}
+	return l[i].hash < l[j].hash
+}
+
+func (s *ManifestStream) buildIndex(info os.FileInfo) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newManifestStreamReader(f)
+	if _, err := r.header(s.Path); err != nil {
+		return err
+	}
+
+	paths := []int64{}
+	hashes := []hashOffset{}
+	for n := 0; ; n++ {
+		offset := r.offset
+		rec, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			break
+		}
+
+		if n%manifestIndexInterval == 0 {
+			paths = append(paths, offset)
+		}
+
+		if rec.Kind == "file" && IsHash(rec.Hash) {
+			hashes = append(hashes, hashOffset{rec.Hash, offset})
+		}
+	}
+
+	sort.Sort(hashOffsets(hashes))
+
+	out, err := createFile(s.IndexPath() + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "%016x %016x %016x %016x\n", info.Size(),
+		info.ModTime().UnixNano(), len(paths), len(hashes))
+	for _, o := range paths {
+		fmt.Fprintf(w, "%016x\n", o)
+	}
+	for _, h := range hashes {
+		fmt.Fprintf(w, "%s %016x\n", h.hash, h.offset)
+	}
+
+	err = w.Flush()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	return os.Rename(out.Name(), s.IndexPath())
+}
+
+func (idx *manifestIndex) Close() error {
+	idx.idx.Close()
+	return idx.mf.Close()
+}
+
+func (idx *manifestIndex) pathOffset(i int) int64 {
+	buf := make([]byte, indexPathLen)
+	idx.idx.ReadAt(buf, indexHeaderLen+int64(i)*indexPathLen)
+	o, _ := strconv.ParseInt(string(buf[:16]), 16, 64)
+	return o
+}
+
+func (idx *manifestIndex) hashEntry(i int) (string, int64) {
+	buf := make([]byte, indexHashLen)
+	off := indexHeaderLen + idx.paths*indexPathLen + int64(i)*indexHashLen
+	idx.idx.ReadAt(buf, off)
+	o, _ := strconv.ParseInt(string(buf[41:57]), 16, 64)
+	return string(buf[:40]), o
+}
+
+func (idx *manifestIndex) recordAt(offset int64) (*ManifestRecord, error) {
+	r := newManifestStreamReader(io.NewSectionReader(idx.mf, offset,
+		idx.size-offset))
+	rec, err := r.Next()
+	if err == nil && rec == nil {
+		err = fmt.Errorf("invalid manifest index offset %d", offset)
+	}
+	return rec, err
+}
+
+// Returns the paths with hash in the manifest at path, using the index
+// for large manifests.
+func manifestPathsForHash(path, hash string) ([]string, error) {
+	s, err := OpenManifestStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if s != nil {
+		return s.PathsForHash(hash)
+	}
+	return NewManifest(path).PathsForHash(hash), nil
+}
+
+// Returns the blobs in the manifest at path, streaming large manifests.
+func manifestBlobPaths(path string) (blobPaths, error) {
+	s, err := OpenManifestStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if s != nil {
+		return s.BlobPaths()
+	}
+	return validBlobHashes(NewManifest(path).Files), nil
+}
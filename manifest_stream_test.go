@@ -0,0 +1,256 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testFullManifest() *Manifest {
+	mf := NewManifest("")
+	mf.Symlinks = SymlinksLink
+	mf.Files["a.csv"] = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	mf.Files["b/c\ttab.txt"] = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	mf.Files["new.txt"] = noHash
+	mf.Meta["a.csv"] = &FileMeta{Size: 10, Mode: 0644, Type: "text/csv"}
+	mf.Meta["b/c\ttab.txt"] = &FileMeta{Size: 3, Mode: 0600}
+	mf.Dirs["empty"] = &FileMeta{Mode: 0755}
+	mf.Links["l"] = "b/c\ttab.txt"
+	return mf
+}
+
+func TestManifestFormatRoundTrip(t *testing.T) {
+	flat := NewManifest("")
+	flat.Files["a.csv"] = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+
+	tests := []struct {
+		name   string
+		mf     *Manifest
+		stream bool
+	}{
+		{"flat yaml", flat, false},
+		{"yaml", testFullManifest(), false},
+		{"stream", testFullManifest(), true},
+		{"flat stream", flat, true},
+	}
+
+	for _, tt := range tests {
+		tt.mf.Stream = tt.stream
+		buf, err := tt.mf.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isManifestStream(buf) != tt.stream {
+			t.Errorf("%s: written as stream: %v", tt.name, !tt.stream)
+		}
+
+		mf := NewManifest("")
+		if err := mf.Unmarshal(buf); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if mf.Stream != tt.stream || mf.Merkle != tt.mf.Merkle ||
+			mf.Symlinks != tt.mf.Symlinks {
+			t.Errorf("%s: options not kept: %+v", tt.name, mf)
+		}
+		if !reflect.DeepEqual(mf.Records(), tt.mf.Records()) {
+			t.Errorf("%s: entries not kept:\n%v\n%v", tt.name, mf.Records(),
+				tt.mf.Records())
+		}
+
+		again, err := mf.Marshal()
+		if err != nil || string(again) != string(buf) {
+			t.Errorf("%s: not written the same (%v)", tt.name, err)
+		}
+	}
+}
+
+func TestManifestFormatIgnoresSize(t *testing.T) {
+	defer func(n int) { ManifestStreamThreshold = n }(ManifestStreamThreshold)
+	ManifestStreamThreshold = 1
+
+	buf, err := testFullManifest().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isManifestStream(buf) {
+		t.Error("large yaml manifest written as stream")
+	}
+}
+
+// Makes a dataset directory tree in a temporary dir, and changes to it.
+// Returns a function to change back and remove it.
+func testDatasetDir(t *testing.T, files ...string) func() {
+	dir, err := ioutil.TempDir("", "data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if strings.HasSuffix(f, "/") {
+			err = os.MkdirAll(p, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+			err = ioutil.WriteFile(p, []byte(f), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+var testTree = []string{
+	"a.txt", "a/b.txt", "b/", "b.txt", "c/d/", "c.d", "c-", "a b",
+	".hidden", ".data/x", "datasets/foo/bar/Datafile",
+}
+
+func TestWalkEntries(t *testing.T) {
+	defer testDatasetDir(t, testTree...)()
+
+	got := []string{}
+	var last *ManifestRecord
+	err := walkEntries(".", SymlinksFollow, func(r *ManifestRecord) error {
+		if last != nil && !recordLess(last, r) {
+			t.Errorf("out of order: %s, %s", last.Path, r.Path)
+		}
+		last = r
+		got = append(got, r.Kind+" "+r.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"file a b", "file a.txt", "file a/b.txt", "dir b", "file b.txt",
+		"file c-", "file c.d", "dir c/d",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("walked %v, expected %v", got, expected)
+	}
+}
+
+func TestManifestStreamGenerate(t *testing.T) {
+	defer testDatasetDir(t, testTree...)()
+
+	mf := NewManifest(".data/Manifest.yaml")
+	if err := mf.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// (converting to a stream)
+	smf := NewManifest(ManifestFileName)
+	smf.Stream = true
+	if err := smf.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(smf.Records(), mf.Records()) {
+		t.Errorf("stream entries differ:\n%v\n%v", smf.Records(), mf.Records())
+	}
+
+	// patching keeps the format, and the entries hashed.
+	if err := ioutil.WriteFile("a/new", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove("b.txt")
+
+	s, err := OpenManifestStream(ManifestFileName)
+	if err != nil || s == nil {
+		t.Fatalf("not a stream (%v)", err)
+	}
+	if err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	smf = NewManifest(ManifestFileName)
+	if !smf.Stream || len(smf.UnhashedPaths()) > 0 {
+		t.Errorf("stream not kept, or unhashed: %v", smf.UnhashedPaths())
+	}
+	if smf.HashForPath("a.txt") != mf.HashForPath("a.txt") ||
+		!IsHash(smf.HashForPath("a/new")) {
+		t.Errorf("entries not patched: %v", smf.Files)
+	}
+	if len(smf.MissingPaths()) != 1 {
+		t.Errorf("missing b.txt not kept: %v", smf.MissingPaths())
+	}
+
+	if _, err := os.Stat(s.JournalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal not removed (%v)", err)
+	}
+}
+
+func TestManifestStreamJournal(t *testing.T) {
+	defer testDatasetDir(t, "a", "b", "c")()
+
+	mf := NewManifest(ManifestFileName)
+	mf.Stream = true
+	for _, p := range []string{"a", "b", "c"} {
+		mf.Files[p] = noHash
+	}
+	if err := mf.WriteFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// journal left by an interrupted run (last line incomplete).
+	s, err := OpenManifestStream(ManifestFileName)
+	if err != nil || s == nil {
+		t.Fatalf("not a stream (%v)", err)
+	}
+
+	a := &ManifestRecord{Kind: "file", Path: "a",
+		Hash: "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8", Size: 1, Mode: "0644"}
+	gone := &ManifestRecord{Kind: "file", Path: "aa",
+		Hash: "e9d71f5ee7c92d6dc9e92ffdad17b8bd49418f98", Size: 1, Mode: "0644"}
+	c := &ManifestRecord{Kind: "file", Path: "c",
+		Hash: "84a516841ba77a5b4648de2cd0dfcb30ea46dbb4", Size: 1, Mode: "0644"}
+	journal := a.line() + gone.line() + c.line()[:20]
+	if err := ioutil.WriteFile(s.JournalPath(), []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.applyJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	mf = NewManifest(ManifestFileName)
+	expected := blobPaths{"a": a.Hash, "b": noHash, "c": noHash}
+	if !reflect.DeepEqual(mf.Files, expected) {
+		t.Errorf("journal applied: %v, expected %v", mf.Files, expected)
+	}
+	if _, err := os.Stat(s.JournalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal not removed (%v)", err)
+	}
+}
+
+func TestMediaType(t *testing.T) {
+	tests := map[string]string{
+		"a.csv":       "text/csv",
+		"data/B.JSON": "application/json",
+		"a.tar.gz":    "application/gzip",
+		"a.unknown":   "",
+		"Datafile":    "",
+	}
+
+	for p, expected := range tests {
+		if typ := mediaType(p); typ != expected {
+			t.Errorf("%s: %q, expected %q", p, typ, expected)
+		}
+	}
+}
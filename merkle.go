@@ -0,0 +1,233 @@
+package data
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Merkle tree manifest hashing.
+//
+// Manifests can be hashed as a Merkle tree (RFC 6962 shape, with sha1)
+// over their entries, sorted by path. The root can then be used as the
+// dataset ref, and any single entry can be proven to belong to a dataset
+// version with a compact proof (log2(n) hashes), without the full manifest.
+//
+// Leaves are the manifest entries: files (path, hash, and any recorded
+// size, mode and type), empty directories, and symlinks.
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// Manifest trees hash with sha1 (like blobs). The tree functions take the
+// hash function, so they can be checked against the RFC (sha256) vectors.
+type merkleHasher func() hash.Hash
+
+var manifestMerkleHasher merkleHasher = sha1.New
+
+// Serializable into YAML. One manifest entry, as hashed into a leaf.
+type MerkleLeaf struct {
+	Kind   string // file, dir, or link
+	Path   string
+	Hash   string ",omitempty"
+	Size   int64  ",omitempty"
+	Mode   string ",omitempty"
+	Type   string ",omitempty"
+	Target string ",omitempty"
+}
+
+func (l *MerkleLeaf) Bytes() []byte {
+	fields := []string{l.Kind, l.Path, l.Hash, fmt.Sprint(l.Size), l.Mode,
+		l.Type, l.Target}
+	return []byte(strings.Join(fields, "\x00"))
+}
+
+func (l *MerkleLeaf) LeafHash() []byte {
+	return manifestMerkleHasher.leafHash(l.Bytes())
+}
+
+// Returns the manifest entries as Merkle leaves, sorted by path.
+func (mf *Manifest) MerkleLeaves() []*MerkleLeaf {
+	leaves := []*MerkleLeaf{}
+	for _, r := range mf.Records() {
+		l := MerkleLeaf(*r)
+		leaves = append(leaves, &l)
+	}
+	return leaves
+}
+
+// Returns the (hex) Merkle root of the manifest entries.
+func (mf *Manifest) MerkleRoot() string {
+	hashes := merkleLeafHashes(mf.MerkleLeaves())
+	return fmt.Sprintf("%x", manifestMerkleHasher.treeHash(hashes))
+}
+
+// Returns the proof that the entry at path is in the manifest.
+func (mf *Manifest) MerkleProof(path string) (*MerkleProof, error) {
+	leaves := mf.MerkleLeaves()
+
+	index := -1
+	for i, l := range leaves {
+		if l.Path == path {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return nil, fmt.Errorf("data manifest: file not in manifest %s", path)
+	}
+
+	hashes := merkleLeafHashes(leaves)
+	p := &MerkleProof{
+		Root:   fmt.Sprintf("%x", manifestMerkleHasher.treeHash(hashes)),
+		Leaf:   *leaves[index],
+		Index:  index,
+		Leaves: len(leaves),
+	}
+
+	for _, h := range manifestMerkleHasher.auditPath(index, hashes) {
+		p.Nodes = append(p.Nodes, fmt.Sprintf("%x", h))
+	}
+	return p, nil
+}
+
+// Serializable into YAML. Proves Leaf is in the tree with Root.
+type MerkleProof struct {
+	Root   string
+	Leaf   MerkleLeaf
+	Index  int
+	Leaves int
+	Nodes  []string // audit path, from leaf to root.
+}
+
+// Verifies the proof is consistent, and its root matches ref.
+func (p *MerkleProof) Verify(ref string) error {
+	if p.Root != ref {
+		return fmt.Errorf("proof root %.7s does not match ref %.7s", p.Root, ref)
+	}
+
+	if p.Index < 0 || p.Index >= p.Leaves {
+		return fmt.Errorf("proof index %d out of range", p.Index)
+	}
+
+	nodes := [][]byte{}
+	for _, n := range p.Nodes {
+		b, err := hexBytes(n)
+		if err != nil {
+			return fmt.Errorf("invalid proof node %s", n)
+		}
+		nodes = append(nodes, b)
+	}
+
+	r, err := manifestMerkleHasher.proofRoot(p.Leaf.LeafHash(), p.Index,
+		p.Leaves, nodes)
+	if err != nil {
+		return err
+	}
+
+	if fmt.Sprintf("%x", r) != p.Root {
+		return fmt.Errorf("proof does not hash to root %.7s", p.Root)
+	}
+	return nil
+}
+
+// RFC 6962 (RFC 9162, 2.1.3.2) inclusion proof verification: returns the
+// root that leaf hash r, at index of a tree with size leaves, and audit
+// path nodes hash to.
+func (h merkleHasher) proofRoot(r []byte, index int, size int,
+	nodes [][]byte) ([]byte, error) {
+
+	fn, sn := index, size-1
+	for _, n := range nodes {
+		if sn == 0 {
+			return nil, fmt.Errorf("proof too long")
+		}
+
+		if fn%2 == 1 || fn == sn {
+			r = h.sum([]byte{merkleNodePrefix}, n, r)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = h.sum([]byte{merkleNodePrefix}, r, n)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return nil, fmt.Errorf("proof too short")
+	}
+	return r, nil
+}
+
+func (h merkleHasher) sum(parts ...[]byte) []byte {
+	s := h()
+	for _, p := range parts {
+		s.Write(p)
+	}
+	return s.Sum(nil)
+}
+
+func (h merkleHasher) leafHash(data []byte) []byte {
+	return h.sum([]byte{merkleLeafPrefix}, data)
+}
+
+func merkleLeafHashes(leaves []*MerkleLeaf) [][]byte {
+	hashes := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = l.LeafHash()
+	}
+	return hashes
+}
+
+// largest power of two smaller than n (n > 1)
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Merkle tree hash of the leaf hashes (RFC 9162, 2.1.1).
+func (h merkleHasher) treeHash(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		return h.sum()
+	case 1:
+		return hashes[0]
+	}
+
+	k := merkleSplit(len(hashes))
+	l := h.treeHash(hashes[:k])
+	r := h.treeHash(hashes[k:])
+	return h.sum([]byte{merkleNodePrefix}, l, r)
+}
+
+// Audit path of leaf m (RFC 9162, 2.1.3.1).
+func (h merkleHasher) auditPath(m int, hashes [][]byte) [][]byte {
+	if len(hashes) <= 1 {
+		return nil
+	}
+
+	k := merkleSplit(len(hashes))
+	if m < k {
+		return append(h.auditPath(m, hashes[:k]), h.treeHash(hashes[k:]))
+	}
+	return append(h.auditPath(m-k, hashes[k:]), h.treeHash(hashes[:k]))
+}
+
+func hexBytes(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha1.Size {
+		return nil, fmt.Errorf("invalid hash: %s", s)
+	}
+	return b, nil
+}
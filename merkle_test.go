@@ -0,0 +1,222 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// RFC 6962 test vectors (sha256), as used by Certificate Transparency.
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var rfc6962Hasher = merkleHasher(sha256.New)
+
+func rfc6962LeafHashes(t *testing.T, n int) [][]byte {
+	hashes := [][]byte{}
+	for _, l := range rfc6962Leaves[:n] {
+		hashes = append(hashes, rfc6962Hasher.leafHash(unhex(t, l)))
+	}
+	return hashes
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMerkleTreeHashVectors(t *testing.T) {
+	empty := rfc6962Hasher.treeHash(nil)
+	if hex.EncodeToString(empty) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("empty tree hash: %x", empty)
+	}
+
+	for n := 1; n <= len(rfc6962Leaves); n++ {
+		root := rfc6962Hasher.treeHash(rfc6962LeafHashes(t, n))
+		if hex.EncodeToString(root) != rfc6962Roots[n-1] {
+			t.Errorf("%d leaves: root %x, expected %s", n, root, rfc6962Roots[n-1])
+		}
+	}
+}
+
+func TestMerkleAuditPathVectors(t *testing.T) {
+	tests := []struct {
+		leaves int
+		index  int
+		path   []string
+	}{
+		{1, 0, nil},
+		{2, 0, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		}},
+		{2, 1, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		}},
+		{3, 0, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"0298d122906dcfc10892cb53a73992fc5b9f493ea4c9badb27b791b4127a7fe7",
+		}},
+		{3, 2, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{7, 0, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"837dbb152e9b079010717e84e865da4ebc0fa198a806d59d31bf15accef22d0e",
+		}},
+		{7, 3, []string{
+			"0298d122906dcfc10892cb53a73992fc5b9f493ea4c9badb27b791b4127a7fe7",
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+			"837dbb152e9b079010717e84e865da4ebc0fa198a806d59d31bf15accef22d0e",
+		}},
+		{7, 4, []string{
+			"4271a26be0d8a84f0bd54c8c302e7cb3a3b5d1fa6780a40bcce2873477dab658",
+			"b08693ec2e721597130641e8211e7eedccb4c26413963eee6c1e2ed16ffb1a5f",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{7, 6, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{8, 0, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+	}
+
+	for _, tt := range tests {
+		hashes := rfc6962LeafHashes(t, tt.leaves)
+		path := rfc6962Hasher.auditPath(tt.index, hashes)
+
+		got := []string{}
+		for _, h := range path {
+			got = append(got, hex.EncodeToString(h))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.path, ",") {
+			t.Errorf("leaf %d of %d: path %v, expected %v", tt.index,
+				tt.leaves, got, tt.path)
+		}
+	}
+}
+
+func TestMerkleProofRoot(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 7} {
+		hashes := rfc6962LeafHashes(t, n)
+		root := hex.EncodeToString(rfc6962Hasher.treeHash(hashes))
+
+		for i := 0; i < n; i++ {
+			path := rfc6962Hasher.auditPath(i, hashes)
+			r, err := rfc6962Hasher.proofRoot(hashes[i], i, n, path)
+			if err != nil || hex.EncodeToString(r) != root {
+				t.Errorf("leaf %d of %d: root %x (%v), expected %s", i, n, r,
+					err, root)
+			}
+
+			// wrong leaf, or wrong index, must not reach the root.
+			if n > 1 {
+				r, _ := rfc6962Hasher.proofRoot(hashes[(i+1)%n], i, n, path)
+				if hex.EncodeToString(r) == root {
+					t.Errorf("leaf %d of %d: proved wrong leaf", i, n)
+				}
+			}
+
+			// truncated and extended paths are rejected.
+			if len(path) > 0 {
+				if _, err := rfc6962Hasher.proofRoot(hashes[i], i, n,
+					path[:len(path)-1]); err == nil {
+					t.Errorf("leaf %d of %d: short proof accepted", i, n)
+				}
+			}
+			long := append(append([][]byte{}, path...), hashes[0])
+			if _, err := rfc6962Hasher.proofRoot(hashes[i], i, n, long); err == nil {
+				t.Errorf("leaf %d of %d: long proof accepted", i, n)
+			}
+		}
+	}
+}
+
+func testMerkleManifest() *Manifest {
+	mf := NewManifest("")
+	mf.Merkle = true
+	mf.Files["a.csv"] = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	mf.Files["b/c.txt"] = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	mf.Files["d"] = "7c211433f02071597741e6ff5a8ea34789abbf43"
+	return mf
+}
+
+func TestManifestMerkleProof(t *testing.T) {
+	mf := testMerkleManifest()
+	root := mf.MerkleRoot()
+
+	for p, _ := range mf.Files {
+		proof, err := mf.MerkleProof(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(root); err != nil {
+			t.Errorf("%s: %s", p, err)
+		}
+
+		tampered := *proof
+		tampered.Leaf.Hash = "0000000000000000000000000000000000000000"
+		if err := tampered.Verify(root); err == nil {
+			t.Errorf("%s: tampered proof verified", p)
+		}
+	}
+
+	if _, err := mf.MerkleProof("missing"); err == nil {
+		t.Error("proof for missing path")
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	for _, merkle := range []bool{false, true} {
+		mf := testMerkleManifest()
+		mf.Merkle = merkle
+
+		buf, err := mf.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := mf.ManifestHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := verifyManifest(buf, ref); err != nil {
+			t.Errorf("merkle %v: %s", merkle, err)
+		}
+
+		bad := strings.Replace(string(buf), "aaf4c61d", "aaf4c61e", 1)
+		if bad == string(buf) {
+			t.Fatal("manifest not tampered")
+		}
+		if _, err := verifyManifest([]byte(bad), ref); err == nil {
+			t.Errorf("merkle %v: tampered manifest accepted", merkle)
+		}
+	}
+}
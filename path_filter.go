@@ -0,0 +1,125 @@
+package data
+
+import (
+	"fmt"
+	"github.com/jbenet/commander"
+	"path"
+	"strings"
+)
+
+// Sparse downloads.
+//
+// A PathFilter selects manifest paths with include and exclude globs
+// (see path.Match). A glob matches a path if it matches the path itself,
+// or any of its parent directories, so 'train' (or 'train/') selects
+// everything beneath train/. Globs without a '/' match names at any
+// depth, so '*.csv' selects all csv files.
+//
+// A path is selected if it matches any include glob (or there are none),
+// and matches no exclude glob.
+
+type PathFilter struct {
+	Include []string ",omitempty"
+	Exclude []string ",omitempty"
+}
+
+// Returns a filter from comma-separated include and exclude globs, or nil
+// if both are empty.
+func NewPathFilter(include, exclude string) (*PathFilter, error) {
+	f := &PathFilter{Include: splitGlobs(include), Exclude: splitGlobs(exclude)}
+	if f.Empty() {
+		return nil, nil
+	}
+
+	for _, g := range append(f.Include, f.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("invalid path glob %q: %s", g, err)
+		}
+	}
+	return f, nil
+}
+
+func splitGlobs(s string) []string {
+	globs := []string{}
+	for _, g := range strings.Split(s, ",") {
+		g = strings.Trim(strings.TrimSpace(g), "/")
+		if len(g) > 0 {
+			globs = append(globs, g)
+		}
+	}
+	return globs
+}
+
+// Whether the filter selects all paths. (nil filters do)
+func (f *PathFilter) Empty() bool {
+	return f == nil || (len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// Whether the filter selects (manifest) path p.
+func (f *PathFilter) Match(p string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	if len(f.Include) > 0 && !matchAnyGlob(f.Include, p) {
+		return false
+	}
+	return !matchAnyGlob(f.Exclude, p)
+}
+
+func matchAnyGlob(globs []string, p string) bool {
+	for _, g := range globs {
+		if matchGlob(g, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(g string, p string) bool {
+	anyDepth := !strings.Contains(g, "/")
+	for ; p != "." && p != "/"; p = path.Dir(p) {
+		name := p
+		if anyDepth {
+			name = path.Base(p)
+		}
+
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the blobs { path : hash } selected by the filter.
+func (f *PathFilter) Blobs(blobs blobPaths) blobPaths {
+	selected := blobPaths{}
+	for p, h := range blobs {
+		if f.Match(p) {
+			selected[p] = h
+		}
+	}
+	return selected
+}
+
+func (f *PathFilter) String() string {
+	if f.Empty() {
+		return "all files"
+	}
+
+	s := []string{}
+	if len(f.Include) > 0 {
+		s = append(s, "include "+strings.Join(f.Include, ","))
+	}
+	if len(f.Exclude) > 0 {
+		s = append(s, "exclude "+strings.Join(f.Exclude, ","))
+	}
+	return strings.Join(s, ", ")
+}
+
+// Returns the filter given by the command's --include and --exclude flags.
+func pathFilterFlags(c *commander.Command) (*PathFilter, error) {
+	include := c.Flag.Lookup("include").Value.Get().(string)
+	exclude := c.Flag.Lookup("exclude").Value.Get().(string)
+	return NewPathFilter(include, exclude)
+}
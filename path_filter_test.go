@@ -0,0 +1,97 @@
+package data
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPathFilterMatch(t *testing.T) {
+	tests := []struct {
+		include string
+		exclude string
+		path    string
+		match   bool
+	}{
+		// no filter
+		{"", "", "a/b.csv", true},
+
+		// anchored globs (with a '/') match from the top.
+		{"train/*.csv", "", "train/a.csv", true},
+		{"train/*.csv", "", "train/x/a.csv", false},
+		{"train/*.csv", "", "test/train/a.csv", false},
+		{"*/a.csv", "", "train/a.csv", true},
+		{"*/a.csv", "", "a.csv", false},
+
+		// globs without a '/' match names at any depth.
+		{"*.csv", "", "a.csv", true},
+		{"*.csv", "", "train/x/a.csv", true},
+		{"*.csv", "", "train/a.txt", false},
+		{"a.csv", "", "train/a.csv", true},
+
+		// globs match parent directories too.
+		{"train", "", "train/x/a.csv", true},
+		{"train/", "", "train/a.csv", true},
+		{"train/x", "", "train/x/a.csv", true},
+		{"train/x", "", "train/y/a.csv", false},
+		{"train", "", "training/a.csv", false},
+		{"x", "", "train/x/a.csv", true},
+
+		// exclude wins over include.
+		{"train", "*.tmp", "train/a.tmp", false},
+		{"train", "*.tmp", "train/a.csv", true},
+		{"", "train/x", "train/x/a.csv", false},
+		{"", "train/x", "train/a.csv", true},
+		{"a.csv,b.csv", "", "b.csv", true},
+		{"a.csv, b.csv", "", "c.csv", false},
+	}
+
+	for _, tt := range tests {
+		f, err := NewPathFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Match(tt.path) != tt.match {
+			t.Errorf("include %q exclude %q: %s matches %v", tt.include,
+				tt.exclude, tt.path, !tt.match)
+		}
+	}
+
+	if _, err := NewPathFilter("[", ""); err == nil {
+		t.Error("invalid glob accepted")
+	}
+}
+
+func TestSelectedBlobs(t *testing.T) {
+	mf := NewManifest("")
+	mf.Files[DatafileName] = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	mf.Files["train/a.csv"] = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	mf.Files["test/a.csv"] = "7c211433f02071597741e6ff5a8ea34789abbf43"
+	out := &bytes.Buffer{}
+	p := &Pack{manifest: mf, index: &DataIndex{out: out}}
+
+	for _, exclude := range []string{"Datafile", "*"} {
+		f, err := NewPathFilter("test", exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blobs, err := p.selectedBlobs(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, datafile := blobs[DatafileName]
+		_, manifest := blobs[ManifestFileName]
+		if !datafile || !manifest {
+			t.Errorf("exclude %s: Datafile (%v) or Manifest (%v) not selected",
+				exclude, datafile, manifest)
+		}
+	}
+
+	// progress goes to the index's output.
+	if !strings.Contains(out.String(), "Warning: no files match") {
+		t.Errorf("index output: %q", out.String())
+	}
+}
@@ -10,6 +10,7 @@ var PathRegexp *regexp.Regexp
 var EmailRegexp *regexp.Regexp
 var HandleRegexp *regexp.Regexp
 var NonIdentRegexp *regexp.Regexp
+var PersonRegexp *regexp.Regexp
 
 func init() {
 	identRE := "[A-Za-z0-9-_.]+"
@@ -18,12 +19,16 @@ func init() {
 	emailRE := `(?i)[A-Z0-9._%+-]+@(?:[A-Z0-9-]+\.)+[A-Z]{2,6}`
 	nonIdentRE := "[^A-Za-z0-9-_.]+"
 
+	// Author Name [<email>] [(url)]
+	personRE := `([^<>()]*[^<>()\s])(?:\s+<([^<>]*)>)?(?:\s+\(([^()]*)\))?`
+
 	UserRegexp = compileRegexp("^" + identRE + "$")
 	IdentRegexp = compileRegexp("^" + identRE + "$")
 	PathRegexp = compileRegexp("^" + pathRE + "$")
 	EmailRegexp = compileRegexp("^" + emailRE + "$")
 	HandleRegexp = compileRegexp("^" + handleRE + "$")
 	NonIdentRegexp = compileRegexp(nonIdentRE)
+	PersonRegexp = compileRegexp("^" + personRE + "$")
 }
 
 func compileRegexp(s string) *regexp.Regexp {
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"fmt"
+)
+
+// Package repair.
+//
+// Files that fail their checksum (or are missing) are restored from their
+// blobs: from a local copy (another path with the same, intact contents),
+// or from the remote blobstore. Restored contents are verified as they
+// are written (see getBlob).
+
+const (
+	RepairFromLocal  = "local copy"
+	RepairFromRemote = "blobstore"
+	RepairMissing    = "missing"
+)
+
+// Checks all files, returning the number of files checked, and those that
+// failed { path : hash }.
+func (p *Pack) checkFailures() (int, blobPaths, error) {
+
+	// check large manifests as a stream.
+	s, err := OpenManifestStream(p.manifest.Path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if s != nil {
+		return s.CheckFailures()
+	}
+
+	stats := newHashStats()
+	failed := checkFiles(p.root, p.manifest.Files, stats)
+	if stats.Files > 1 {
+		pErr("data manifest: checked %s\n", stats)
+	}
+	return len(p.manifest.Files), failed, nil
+}
+
+// Returns where each failed file { path : hash } would be restored from.
+func (p *Pack) repairSources(failed blobPaths) (map[string]string, error) {
+
+	sources := map[string]string{}
+	for _, path := range sortedPaths(failed) {
+		hash := failed[path]
+
+		// other paths with the same hash that passed the check.
+		copies, err := manifestPathsForHash(p.manifest.Path, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		sources[path] = ""
+		for _, c := range copies {
+			if _, bad := failed[c]; !bad {
+				sources[path] = RepairFromLocal
+				break
+			}
+		}
+		if len(sources[path]) > 0 {
+			continue
+		}
+
+		exists, err := p.index.hasBlob(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		sources[path] = RepairMissing
+		if exists {
+			sources[path] = RepairFromRemote
+		}
+	}
+	return sources, nil
+}
+
+// Checks all files, and restores those that fail from their blobs. With
+// dryRun, only reports what would be restored.
+func (p *Pack) Repair(dryRun bool) error {
+	if !p.manifest.Complete() {
+		return fmt.Errorf(ManifestIncompleteMsg)
+	}
+
+	// never write outside the dataset directory.
+	if err := p.manifest.ValidatePaths(); err != nil {
+		return err
+	}
+
+	total, failed, err := p.checkFailures()
+	if err != nil {
+		return err
+	}
+
+	if len(failed) == 0 {
+		pOut("data pack: all %d checksums pass. Nothing to repair.\n", total)
+		return nil
+	}
+
+	sources, err := p.repairSources(failed)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		counts := map[string]int{}
+		for _, path := range sortedPaths(failed) {
+			pOut("restore %.7s %s (from %s)\n", failed[path], path,
+				sources[path])
+			counts[sources[path]]++
+		}
+
+		pOut("\ndata pack: %d/%d files would be restored (%d from local copies,"+
+			" %d from blobstore), %d missing. (dry run, nothing restored.)\n",
+			len(failed)-counts[RepairMissing], total, counts[RepairFromLocal],
+			counts[RepairFromRemote], counts[RepairMissing])
+		return nil
+	}
+
+	cache := openHashCache(p.root)
+	restored := 0
+	for _, path := range sortedPaths(failed) {
+		hash := failed[path]
+		if sources[path] == RepairMissing {
+			pErr("data pack: cannot restore %s: blob %.7s not found.\n", path,
+				hash)
+			continue
+		}
+
+		// (getBlobAt prefers intact local copies, and verifies contents.)
+		if err := p.index.getBlobAt(p.root, hash, path); err != nil {
+			pErr("data pack: cannot restore %s: %s\n", path, err)
+			continue
+		}
+
+		cache.Put(path, hash)
+		restored++
+	}
+
+	if err := cache.Write(); err != nil {
+		pErr("Warning: could not write hash cache: %s\n", err)
+	}
+
+	// restore file permissions
+	if err := p.manifest.RestoreMeta(nil, p.index.output()); err != nil {
+		return err
+	}
+
+	pOut("data pack: restored %d/%d failed files (%d checked).\n", restored,
+		len(failed), total)
+	if restored < len(failed) {
+		return fmt.Errorf("data pack: %d files could not be restored.",
+			len(failed)-restored)
+	}
+	return nil
+}
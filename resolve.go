@@ -0,0 +1,363 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dependency resolution.
+//
+// Dependencies (in Datafiles) name a dataset, and optionally a version
+// range: <author>/<name>[@<range>] (see version_range.go). Installing a
+// dataset also installs its dependencies, transitively: each selected
+// dataset version's Datafile is fetched (without installing it), and its
+// dependencies resolved in turn.
+//
+// One version of each dataset is selected: the latest version satisfying
+// all ranges on it. (If no ranges constrain it, the latest published
+// version.) Preferred versions (e.g. from Datafile.lock) are kept if they
+// still satisfy all ranges. Selection repeats until no selected version
+// changes. If no version satisfies all ranges, resolution fails,
+// explaining which datasets required what.
+//
+// Manifests and Datafiles fetched are verified against their refs, so
+// dependencies cannot be injected on the way.
+
+// A dependency: a dataset, and version range required by another dataset
+// (or by the project's Datafile).
+type Dependency struct {
+	Path  string // <author>/<name>
+	Range *VersionRange
+	From  string // dependent dataset (<author>/<name>@<version>), or "".
+}
+
+// Parses a dependency: <author>/<name>[.<format>][@<range>]
+func ParseDependency(s string) (*Dependency, error) {
+	name, rng := s, ""
+	if i := strings.Index(s, "@"); i >= 0 {
+		name, rng = s[:i], s[i+1:]
+	}
+
+	h := NewHandle(strings.ToLower(name))
+	if !PathRegexp.MatchString(h.Path()) {
+		return nil, fmt.Errorf("invalid dependency %q. Should be "+
+			"<author>/<name>[@<version range>].", s)
+	}
+
+	r, err := ParseVersionRange(rng)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dependency %q: %s", s, err)
+	}
+	return &Dependency{Path: h.Path(), Range: r}, nil
+}
+
+// Parses dependencies, as required by from.
+func parseDependencies(deps []string, from string) ([]*Dependency, error) {
+	l := []*Dependency{}
+	for _, s := range deps {
+		d, err := ParseDependency(s)
+		if err != nil {
+			return nil, err
+		}
+		d.From = from
+		l = append(l, d)
+	}
+	return l, nil
+}
+
+func (d *Dependency) String() string {
+	if d.Range.Any() {
+		return d.Path
+	}
+	return d.Path + "@" + d.Range.String()
+}
+
+// Who requires the dependency, for messages.
+func (d *Dependency) requiredBy() string {
+	if len(d.From) == 0 {
+		return DatafileName
+	}
+	return d.From
+}
+
+// A dataset version selected for installation.
+type PlannedDataset struct {
+	Path     string
+	Version  string
+	Ref      string
+	Required []*Dependency // the ranges it satisfies.
+}
+
+func (d *PlannedDataset) Handle() *Handle {
+	return NewHandle(d.Path + "@" + d.Version)
+}
+
+// Datasets to install, sorted by path.
+type InstallPlan struct {
+	Datasets []*PlannedDataset
+	Locked   bool // from Datafile.lock (not resolved).
+}
+
+type dependencyResolver struct {
+	di       *DataIndex
+	refs     map[string]*DatasetRefs  // { path : refs }
+	requires map[string][]*Dependency // { manifest ref : dependencies }
+	prefer   map[string]string        // { path : version }
+}
+
+func newDependencyResolver(di *DataIndex) *dependencyResolver {
+	return &dependencyResolver{
+		di:       di,
+		refs:     map[string]*DatasetRefs{},
+		requires: map[string][]*Dependency{},
+	}
+}
+
+// Resolves deps (and their dependencies, transitively) into an install
+// plan, keeping preferred versions { path : version } where possible.
+func ResolveDependencies(di *DataIndex, deps []*Dependency,
+	prefer map[string]string) (*InstallPlan, error) {
+
+	r := newDependencyResolver(di)
+	r.prefer = prefer
+	return r.resolve(deps)
+}
+
+// Resolves deps (see ResolveDependencies).
+func (r *dependencyResolver) resolve(deps []*Dependency) (*InstallPlan,
+	error) {
+
+	selected := map[string]*PlannedDataset{}
+	for round := 0; ; round++ {
+		if round > 100 {
+			return nil, fmt.Errorf("data get: dependencies do not converge.")
+		}
+		if err := r.di.err(); err != nil {
+			return nil, err
+		}
+
+		// all ranges, from the project, and from selected datasets.
+		ranges := map[string][]*Dependency{}
+		for _, d := range deps {
+			ranges[d.Path] = append(ranges[d.Path], d)
+		}
+
+		for _, path := range sortedPlanPaths(selected) {
+			req, err := r.datasetDependencies(selected[path])
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range req {
+				ranges[d.Path] = append(ranges[d.Path], d)
+			}
+		}
+
+		// select a version for each dataset.
+		next := map[string]*PlannedDataset{}
+		for path, rs := range ranges {
+			s, err := r.selectVersion(path, rs)
+			if err != nil {
+				return nil, err
+			}
+			next[path] = s
+		}
+
+		if samePlan(selected, next) {
+			break
+		}
+		selected = next
+	}
+
+	plan := &InstallPlan{}
+	for _, path := range sortedPlanPaths(selected) {
+		plan.Datasets = append(plan.Datasets, selected[path])
+	}
+	return plan, nil
+}
+
+func sortedPlanPaths(selected map[string]*PlannedDataset) []string {
+	paths := []string{}
+	for p, _ := range selected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func samePlan(a, b map[string]*PlannedDataset) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if o, found := b[p]; !found || o.Ref != s.Ref {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the published refs of dataset path.
+func (r *dependencyResolver) datasetRefs(path string) (*DatasetRefs, error) {
+	if refs, found := r.refs[path]; found {
+		return refs, nil
+	}
+
+	ri := r.di.RefIndex(path)
+	if err := ri.FetchRefs(false); err != nil {
+		if strings.Contains(err.Error(), "404 page not found") {
+			return nil, fmt.Errorf("Error: %v not found.", path)
+		}
+		return nil, fmt.Errorf("Error finding versions of %v. %s", path, err)
+	}
+
+	r.refs[path] = ri.Refs
+	return ri.Refs, nil
+}
+
+// Selects the latest version of path in all ranges.
+func (r *dependencyResolver) selectVersion(path string, ranges []*Dependency) (
+	*PlannedDataset, error) {
+
+	refs, err := r.datasetRefs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PlannedDataset{Path: path, Required: ranges}
+
+	// preferred version, if it is in all ranges.
+	if v, found := r.prefer[path]; found && len(refs.Versions[v]) > 0 {
+		ok := true
+		for _, d := range ranges {
+			ok = ok && d.Range.Match(v)
+		}
+
+		if ok {
+			s.Version, s.Ref = v, refs.Versions[v]
+			return s, nil
+		}
+	}
+
+	// unconstrained: latest published.
+	constrained := false
+	for _, d := range ranges {
+		constrained = constrained || !d.Range.Any()
+	}
+	if !constrained {
+		s.Ref = refs.ResolveRef(RefLatest)
+		s.Version = refs.ResolveVersion(s.Ref)
+		if len(s.Ref) == 0 {
+			return nil, fmt.Errorf("Error: %v has no published versions.", path)
+		}
+		return s, nil
+	}
+
+	versions := []string{}
+	for v, _ := range refs.Versions {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(versionsByVersion(versions)))
+
+	for _, v := range versions {
+		ok := true
+		for _, d := range ranges {
+			ok = ok && d.Range.Match(v)
+		}
+
+		if ok {
+			s.Version, s.Ref = v, refs.Versions[v]
+			return s, nil
+		}
+	}
+
+	return nil, versionConflict(path, ranges, versions)
+}
+
+// Explains why no version of path satisfies ranges.
+func versionConflict(path string, ranges []*Dependency, versions []string) error {
+	lines := []string{}
+	for _, d := range ranges {
+		lines = append(lines, fmt.Sprintf("%s requires %s@%s", d.requiredBy(),
+			path, d.Range))
+	}
+	sort.Strings(lines)
+
+	sort.Sort(versionsByVersion(versions))
+	return fmt.Errorf(VersionConflictMsg, path, strings.Join(lines, "\n    "),
+		strings.Join(versions, ", "))
+}
+
+// Returns the dependencies of a selected dataset version, from its
+// Datafile (fetched, not installed).
+func (r *dependencyResolver) datasetDependencies(s *PlannedDataset) (
+	[]*Dependency, error) {
+
+	if deps, found := r.requires[s.Ref]; found {
+		return deps, nil
+	}
+
+	h := s.Handle()
+	dOut("fetching Datafile of %s\n", h.Dataset())
+	mf, err := r.di.verifiedManifest(s.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching manifest for %v. %s",
+			h.Dataset(), err)
+	}
+
+	deps := []*Dependency{}
+	if dfh, found := mf.Files[DatafileName]; found {
+		df, err := r.di.datafileWithRef(dfh)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching Datafile for %v. %s",
+				h.Dataset(), err)
+		}
+
+		deps, err = parseDependencies(df.Dependencies, h.Dataset())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", h.Dataset(), err)
+		}
+	}
+
+	r.requires[s.Ref] = deps
+	return deps, nil
+}
+
+type versionsByVersion []string
+
+func (v versionsByVersion) Len() int           { return len(v) }
+func (v versionsByVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v versionsByVersion) Less(i, j int) bool { return VersionLess(v[i], v[j]) }
+
+// Prints the plan: each dataset, and what requires it.
+func (p *InstallPlan) Print() {
+	if p.Locked {
+		pErr("Install plan (%d datasets, from %s):\n", len(p.Datasets),
+			LockfileName)
+	} else {
+		pErr("Install plan (%d datasets):\n", len(p.Datasets))
+	}
+
+	for _, s := range p.Datasets {
+		if p.Locked {
+			pErr("    %-30s %.7s\n", s.Handle().Dataset(), s.Ref)
+			continue
+		}
+
+		req := []string{}
+		for _, d := range s.Required {
+			req = append(req, fmt.Sprintf("%s (%s)", d.requiredBy(), d.Range))
+		}
+		sort.Strings(req)
+
+		pErr("    %-30s %.7s  required by %s\n", s.Handle().Dataset(), s.Ref,
+			strings.Join(req, ", "))
+	}
+	pErr("\n")
+}
+
+const VersionConflictMsg = `Error: no version of %s satisfies all requirements:
+    %s
+Published versions: %s
+Change the version ranges in the Datafile, or ask the dependent datasets'
+owners to update theirs.`
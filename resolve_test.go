@@ -0,0 +1,188 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A resolver over published versions { path : versions }, and the
+// dependencies of each version { path@version : dependencies }, without
+// an index.
+func testResolver(t *testing.T, published map[string]string,
+	requires map[string][]string) *dependencyResolver {
+
+	r := newDependencyResolver(&DataIndex{})
+	for path, versions := range published {
+		refs := &DatasetRefs{Published: map[string]string{},
+			Versions: map[string]string{}}
+		for i, v := range strings.Fields(versions) {
+			ref := testRef(path, v)
+			refs.Versions[v] = ref
+			refs.Published[ref] = fmt.Sprintf("2014-01-%02dT00:00:00Z", i+1)
+			r.requires[ref] = []*Dependency{}
+		}
+		r.refs[path] = refs
+	}
+
+	for ds, deps := range requires {
+		h := NewHandle(ds)
+		d, err := parseDependencies(deps, h.Dataset())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.requires[testRef(h.Path(), h.Version)] = d
+	}
+	return r
+}
+
+func testRef(path, version string) string {
+	return "ref:" + path + "@" + version
+}
+
+func planString(p *InstallPlan) string {
+	s := []string{}
+	for _, d := range p.Datasets {
+		s = append(s, d.Path+"@"+d.Version)
+	}
+	return strings.Join(s, " ")
+}
+
+var testPublished = map[string]string{
+	"a/a": "1.0 1.1 1.5 2.0 2.1-beta",
+	"b/b": "1.0 2.0",
+	"c/c": "0.1 0.2 0.2.5",
+	"d/d": "1.0",
+}
+
+var testRequires = map[string][]string{
+	"b/b@1.0": {"a/a@^1.0", "c/c@~0.2"},
+	"b/b@2.0": {"a/a@^2.0"},
+	"d/d@1.0": {"a/a@1.1"},
+}
+
+func TestResolveDependencies(t *testing.T) {
+	tests := []struct {
+		deps     string
+		prefer   map[string]string
+		expected string
+	}{
+		{"a/a", nil, "a/a@2.1-beta"}, // (latest published)
+		{"a/a@^1.0", nil, "a/a@1.5"},
+		{"a/a@>=1.0", nil, "a/a@2.0"},
+		{"a/a@1.x b/b@1", nil, "a/a@1.5 b/b@1.0 c/c@0.2.5"},
+		{"b/b@1.0", nil, "a/a@1.5 b/b@1.0 c/c@0.2.5"},
+		{"b/b@^2", nil, "a/a@2.0 b/b@2.0"},
+		{"b/b@1.0 d/d", nil, "a/a@1.1 b/b@1.0 c/c@0.2.5 d/d@1.0"},
+		{"a/a@^1.0", map[string]string{"a/a": "1.1"}, "a/a@1.1"},
+		{"a/a@^1.0", map[string]string{"a/a": "2.0"}, "a/a@1.5"},
+	}
+
+	for _, tt := range tests {
+		deps, err := parseDependencies(strings.Fields(tt.deps), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := testResolver(t, testPublished, testRequires)
+		r.prefer = tt.prefer
+		plan, err := r.resolve(deps)
+		if err != nil {
+			t.Errorf("%s: %s", tt.deps, err)
+			continue
+		}
+
+		if planString(plan) != tt.expected {
+			t.Errorf("%s: resolved %s, expected %s", tt.deps, planString(plan),
+				tt.expected)
+		}
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	tests := []struct {
+		deps     string
+		expected []string
+	}{
+		{"a/a@^3.0", []string{
+			"no version of a/a satisfies",
+			"Datafile requires a/a@^3.0",
+			"Published versions: 1.0, 1.1, 1.5, 2.0, 2.1-beta",
+		}},
+		{"a/a@^2.0 b/b@1.0", []string{
+			"no version of a/a satisfies",
+			"Datafile requires a/a@^2.0\n    b/b@1.0 requires a/a@^1.0",
+		}},
+		{"b/b@1.0 d/d a/a@1.5", []string{
+			"Datafile requires a/a@1.5\n" +
+				"    b/b@1.0 requires a/a@^1.0\n" +
+				"    d/d@1.0 requires a/a@1.1",
+		}},
+		{"c/c@0.1 b/b@1.0", []string{
+			"no version of c/c satisfies",
+			"Datafile requires c/c@0.1\n    b/b@1.0 requires c/c@~0.2",
+			"Published versions: 0.1, 0.2, 0.2.5",
+		}},
+	}
+
+	for _, tt := range tests {
+		deps, err := parseDependencies(strings.Fields(tt.deps), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := testResolver(t, testPublished, testRequires)
+		plan, err := r.resolve(deps)
+		if err == nil {
+			t.Errorf("%s: resolved %s, expected conflict", tt.deps,
+				planString(plan))
+			continue
+		}
+
+		for _, e := range tt.expected {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("%s: error %q does not contain %q", tt.deps, err, e)
+			}
+		}
+	}
+}
+
+func TestVerifyDependencyBlobs(t *testing.T) {
+	df := []byte("dataset: a/b@1.0\ndependencies: [c/d]\n")
+	dfh, err := readerHash(bytes.NewReader(df))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := verifyDatafile(df, dfh); err != nil ||
+		strings.Join(d.Dependencies, " ") != "c/d" {
+		t.Errorf("Datafile: %v (%v)", d, err)
+	}
+
+	injected := []byte("dataset: a/b@1.0\ndependencies: [c/d, evil/x]\n")
+	if _, err := verifyDatafile(injected, dfh); err == nil {
+		t.Error("Datafile not matching its ref accepted")
+	}
+
+	mf := NewManifest("")
+	mf.Files[DatafileName] = dfh
+	buf, err := mf.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfh, err := readerHash(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifyManifest(buf, mfh); err != nil {
+		t.Errorf("manifest: %v", err)
+	}
+
+	mf.Files[DatafileName] = "7c211433f02071597741e6ff5a8ea34789abbf43"
+	buf, _ = mf.Marshal()
+	if _, err := verifyManifest(buf, mfh); err == nil {
+		t.Error("manifest not matching its ref accepted")
+	}
+}
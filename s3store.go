@@ -1,11 +1,14 @@
 package data
 
 import (
+	"context"
 	"fmt"
 	"github.com/jbenet/s3"
 	"github.com/jbenet/s3/s3util"
 	"io"
+	"net/http"
 	"strings"
+	"sync"
 )
 
 type S3Store struct {
@@ -15,6 +18,7 @@ type S3Store struct {
 
 	// used for auth credentials
 	dataIndex *DataIndex
+	credLock  *sync.Mutex
 }
 
 // format from `aws sts` cmd
@@ -38,6 +42,7 @@ func NewS3Store(bucket string, index *DataIndex) (*S3Store, error) {
 		bucket:    bucket,
 		domain:    "s3.amazonaws.com",
 		dataIndex: index,
+		credLock:  &sync.Mutex{},
 	}
 
 	s.config = &s3util.Config{
@@ -48,6 +53,41 @@ func NewS3Store(bucket string, index *DataIndex) (*S3Store, error) {
 	return s, nil
 }
 
+// Returns a copy of the store, for index (a copy of its index, see
+// DataIndex.withContext), whose requests are cancelled with ctx. The
+// copy shares the store's credentials (config.Keys, and their lock):
+// credentials set on either are used by both.
+func (s *S3Store) withContext(index *DataIndex, ctx context.Context) *S3Store {
+	c := *s
+	c.dataIndex = index
+
+	config := *s.config
+	config.Keys = s.config.Keys // (shared, not copied)
+	config.Client = &http.Client{
+		Transport: &contextTransport{ctx, http.DefaultTransport},
+	}
+	c.config = &config
+	return &c
+}
+
+// RoundTripper that makes requests with ctx.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(r.WithContext(t.ctx))
+}
+
+// Returns the client requests are made with.
+func (s *S3Store) client() *http.Client {
+	if s.config.Client != nil {
+		return s.config.Client
+	}
+	return http.DefaultClient
+}
+
 func (s *S3Store) SetAwsCredentials(c *AwsCredentials) {
 	s.config.AccessKey = c.AccessKeyId
 	s.config.SecretKey = c.SecretAccessKey
@@ -119,13 +159,30 @@ func (s *S3Store) Put(key string, value io.Reader) error {
 	return nil
 }
 
+func (s *S3Store) Size(key string) (int64, error) {
+	resp, err := s.client().Head(s.Url(key))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP error status code: %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("unknown size: %s", key)
+	}
+	return resp.ContentLength, nil
+}
+
 func (s *S3Store) Get(key string) (io.ReadCloser, error) {
 	url := s.Url(key)
 	return s3util.Open(url, s.config)
 }
 
 func (s *S3Store) getUserAwsCredentials() error {
-	u := configUser()
+	u := s.dataIndex.Http.User
 	if !isNamedUser(u) {
 		return fmt.Errorf("must be signed in to request aws credentials")
 	}
@@ -141,6 +198,9 @@ func (s *S3Store) getUserAwsCredentials() error {
 }
 
 func (s *S3Store) ensureUserAwsCredentials() error {
+	s.credLock.Lock()
+	defer s.credLock.Unlock()
+
 	// if we already have credentials, do nothing.
 	if s.AwsCredentials() != nil {
 		return nil
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestS3StoreContext(t *testing.T) {
+	h := newHttpClientWithUrl("http://127.0.0.1:1", "someone", "token")
+	i, err := newDataIndex("test", h, "test.bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := i.withContext(ctx)
+	if c.BlobStore.dataIndex != c || i.BlobStore.dataIndex != i {
+		t.Fatal("blobstore not bound to its index")
+	}
+
+	canceled := func(what string, err error) {
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("%s: %v, expected %v", what, err, context.Canceled)
+		}
+	}
+
+	_, err = c.BlobStore.Get("key")
+	canceled("get", err)
+	_, err = c.BlobStore.Has("key")
+	canceled("has", err)
+	_, err = c.BlobStore.Size("key")
+	canceled("size", err)
+
+	// (credentials are requested from the index, with ctx too.)
+	canceled("put", c.BlobStore.Put("key", strings.NewReader("")))
+
+	_, err = c.remoteBlob("2aae6c35c94fcfb415dbe95f408b9ce91ee846ed")
+	canceled("remote blob", err)
+
+	// credentials are shared.
+	c.BlobStore.SetAwsCredentials(&AwsCredentials{AccessKeyId: "key"})
+	if i.BlobStore.config.AccessKey != "key" {
+		t.Error("blobstore credentials not shared with its copy")
+	}
+}
@@ -15,13 +15,27 @@ type SerializedFile struct {
 	Format interface{} "-"
 }
 
+// Formats that are not (always) YAML serialize themselves.
+type fileMarshaler interface {
+	MarshalFile() ([]byte, error)
+	UnmarshalFile(buf []byte) error
+}
+
 func (f *SerializedFile) Marshal() ([]byte, error) {
 	dOut("Marshalling %s\n", f.Path)
+	if m, ok := f.Format.(fileMarshaler); ok {
+		return m.MarshalFile()
+	}
 	return goyaml.Marshal(f.Format)
 }
 
 func (f *SerializedFile) Unmarshal(buf []byte) error {
-	err := goyaml.Unmarshal(buf, f.Format)
+	var err error
+	if m, ok := f.Format.(fileMarshaler); ok {
+		err = m.UnmarshalFile(buf)
+	} else {
+		err = goyaml.Unmarshal(buf, f.Format)
+	}
 	if err != nil {
 		return err
 	}
@@ -86,17 +100,7 @@ func (f *SerializedFile) ReadBlob(ref string) error {
 		return err
 	}
 
-	r, err := i.BlobStore.Get(BlobKey(ref))
-	if err != nil {
-		return err
-	}
-
-	err = f.Read(r)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return i.readBlob(ref, f)
 }
 
 func Marshal(in interface{}) (io.Reader, error) {
@@ -0,0 +1,257 @@
+package data
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Manifest signatures.
+//
+// Publishers sign the manifest hash (ref) of a dataset with an ed25519
+// key, stored in their config (signing.private, signing.public). The
+// signature is stored in the blobstore, alongside the manifest blob.
+//
+// 'data get' verifies signatures against the keys it trusts, which are
+// stored in the config (trusted.<author>). The first key seen for an
+// author is trusted (trust on first use). From then on, datasets by that
+// author must be signed with that key.
+
+// Serializable into YAML.
+type ManifestSignature struct {
+	Dataset   string // <author>/<name>
+	Ref       string
+	Key       string // public key (hex)
+	Signature string // (hex)
+}
+
+// The signed message, binding the ref to the dataset.
+func signatureMessage(dataset, ref string) []byte {
+	return []byte(fmt.Sprintf("data manifest signature\n%s\n%s\n", dataset, ref))
+}
+
+func SignManifest(dataset, ref string, key ed25519.PrivateKey) *ManifestSignature {
+	sig := ed25519.Sign(key, signatureMessage(dataset, ref))
+	pub := key.Public().(ed25519.PublicKey)
+	return &ManifestSignature{
+		Dataset:   dataset,
+		Ref:       ref,
+		Key:       hex.EncodeToString(pub),
+		Signature: hex.EncodeToString(sig),
+	}
+}
+
+// Verifies the signature is valid, and for dataset and ref.
+func (s *ManifestSignature) Verify(dataset, ref string) error {
+	if s.Dataset != dataset || s.Ref != ref {
+		return fmt.Errorf("signature is for %s (%.7s), not %s (%.7s)",
+			s.Dataset, s.Ref, dataset, ref)
+	}
+
+	pub, err := hex.DecodeString(s.Key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid signature key: %s", s.Key)
+	}
+
+	sig, err := hex.DecodeString(s.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", s.Signature)
+	}
+
+	msg := signatureMessage(dataset, ref)
+	if !ed25519.Verify(ed25519.PublicKey(pub), msg, sig) {
+		return fmt.Errorf("bad signature for %s (%.7s)", dataset, ref)
+	}
+	return nil
+}
+
+// Returns the blobstore key for the signature of dataset ref.
+func SignatureKey(dataset, ref string) string {
+	return fmt.Sprintf("/sig/%s/%s", dataset, ref)
+}
+
+// DataIndex extension to store a signature
+func (i *DataIndex) putSignature(s *ManifestSignature) error {
+	rdr, err := Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return i.BlobStore.Put(SignatureKey(s.Dataset, s.Ref), rdr)
+}
+
+// DataIndex extension to get a signature. Returns nil if there is none.
+func (i *DataIndex) getSignature(dataset, ref string) (*ManifestSignature, error) {
+	key := SignatureKey(dataset, ref)
+	exists, err := i.BlobStore.Has(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	r, err := i.remoteGet(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s := &ManifestSignature{}
+	if err := Unmarshal(r, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Verifies the signature of dataset ref against trusted keys (trusting
+// the key on first use). Unsigned datasets pass, unless the author's key
+// is already trusted.
+func (i *DataIndex) verifySignature(h *Handle, ref string) error {
+	dataset := h.Path()
+	trusted := i.trustedKey(h.Author)
+
+	s, err := i.getSignature(dataset, ref)
+	if err != nil {
+		return fmt.Errorf("Error fetching signature for %s. %s", dataset, err)
+	}
+
+	if s == nil {
+		if len(trusted) > 0 {
+			return fmt.Errorf(SignatureMissingMsg, h.Dataset(), ref, h.Author)
+		}
+
+		i.pErr("Warning: %s (%.7s) is not signed.\n", h.Dataset(), ref)
+		return nil
+	}
+
+	if err := s.Verify(dataset, ref); err != nil {
+		return fmt.Errorf(SignatureInvalidMsg, h.Dataset(), ref, err)
+	}
+
+	if len(trusted) == 0 {
+		i.pErr("Trusting %s's key %.16s (first use).\n", h.Author, s.Key)
+		return i.setTrustedKey(h.Author, s.Key)
+	}
+
+	if trusted != s.Key {
+		return fmt.Errorf(SignatureKeyMismatchMsg, h.Dataset(), ref, s.Key,
+			h.Author, trusted, h.Author)
+	}
+
+	i.pErr("Verified signature of %s (%.7s) by %s.\n", h.Dataset(), ref,
+		h.Author)
+	return nil
+}
+
+// Returns the configured signing key, generating one if there is none.
+func configSigningKey() (ed25519.PrivateKey, error) {
+	priv := ConfigGetString("signing.private", "")
+	if len(priv) > 0 {
+		key, err := hex.DecodeString(priv)
+		if err != nil || len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("Config error: invalid signing.private")
+		}
+		return ed25519.PrivateKey(key), nil
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ConfigSet("signing.private", hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+
+	if err := ConfigSet("signing.public", hex.EncodeToString(pub)); err != nil {
+		return nil, err
+	}
+
+	// the config now holds a private key.
+	if err := os.Chmod(globalConfigFile, 0600); err != nil {
+		return nil, err
+	}
+
+	pErr("Generated signing key %.16s (stored in %s).\n",
+		hex.EncodeToString(pub), globalConfigFile)
+	return key, nil
+}
+
+// Trusted keys { author : key }, kept in memory (see Client), instead of
+// the config.
+type trustedKeys struct {
+	sync.Mutex
+	keys map[string]string
+}
+
+func newTrustedKeys(keys map[string]string) *trustedKeys {
+	t := &trustedKeys{keys: map[string]string{}}
+	for a, k := range keys {
+		t.keys[a] = k
+	}
+	return t
+}
+
+// Returns the key the index trusts for author, or "" if there is none.
+func (i *DataIndex) trustedKey(author string) string {
+	if i.trusted == nil {
+		return configTrustedKey(author)
+	}
+
+	i.trusted.Lock()
+	defer i.trusted.Unlock()
+	return i.trusted.keys[author]
+}
+
+func (i *DataIndex) setTrustedKey(author string, key string) error {
+	if i.trusted == nil {
+		return configSetTrustedKey(author, key)
+	}
+
+	i.trusted.Lock()
+	defer i.trusted.Unlock()
+	i.trusted.keys[author] = key
+	return nil
+}
+
+// Returns the trusted key for author, or "" if there is none.
+// (not using ConfigGet, as author names may contain dots.)
+func configTrustedKey(author string) string {
+	loadConfig()
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	t, _ := Config["trusted"].(map[interface{}]interface{})
+	k, _ := t[author].(string)
+	return k
+}
+
+func configSetTrustedKey(author string, key string) error {
+	loadConfig()
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	t, ok := Config["trusted"].(map[interface{}]interface{})
+	if !ok {
+		t = map[interface{}]interface{}{}
+		Config["trusted"] = t
+	}
+
+	t[author] = key
+	return WriteConfigFile(globalConfigFile, &Config)
+}
+
+const SignatureMissingMsg = `Signature missing for %s (%.7s).
+You trust a signing key for %s, but this version is not signed.
+Someone may have tampered with the dataset. Refusing to install.`
+
+const SignatureInvalidMsg = `Signature INVALID for %s (%.7s): %s
+Someone may have tampered with the dataset. Refusing to install.`
+
+const SignatureKeyMismatchMsg = `Signing key MISMATCH for %s (%.7s).
+It is signed with key %s,
+but you trust key %s for %s.
+Someone may have tampered with the dataset. Refusing to install.
+If %s changed keys legitimately, remove the old key from your config
+(see 'data config --edit', under 'trusted') and try again.`
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// Transfer plans (dry runs).
+//
+// Before uploading, downloading, or publishing, a pack can report what
+// would happen: which blobs would be transferred, which skipped (already
+// present), and which are missing (cannot be transferred), with counts
+// and byte totals. Planning performs no writes.
+
+const (
+	PlanUpload   = "upload"
+	PlanDownload = "download"
+	PlanSkip     = "skip"
+	PlanMissing  = "missing"
+)
+
+// One blob in a transfer plan.
+type PlannedBlob struct {
+	Hash   string
+	Paths  []string // sorted
+	Size   int64    // -1 if unknown
+	Action string
+}
+
+type TransferPlan struct {
+	Direction string // PlanUpload or PlanDownload
+	Blobs     []*PlannedBlob
+}
+
+// Groups blobs { path : hash } by hash, sorted by (first) path.
+func plannedBlobs(blobs blobPaths) []*PlannedBlob {
+	byHash := map[string]*PlannedBlob{}
+	for _, path := range sortedPaths(blobs) {
+		h := blobs[path]
+		if b, found := byHash[h]; found {
+			b.Paths = append(b.Paths, path)
+		} else {
+			byHash[h] = &PlannedBlob{Hash: h, Paths: []string{path}, Size: -1}
+		}
+	}
+
+	planned := []*PlannedBlob{}
+	for _, b := range byHash {
+		planned = append(planned, b)
+	}
+	sort.Sort(plannedByPath(planned))
+	return planned
+}
+
+type plannedByPath []*PlannedBlob
+
+func (l plannedByPath) Len() int           { return len(l) }
+func (l plannedByPath) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l plannedByPath) Less(i, j int) bool { return l[i].Paths[0] < l[j].Paths[0] }
+
+// Plans an upload: blobs already in the blobstore are skipped, and blobs
+// not found locally are missing.
+func (p *Pack) UploadPlan() (*TransferPlan, error) {
+	blobs, err := p.BlobPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &TransferPlan{Direction: PlanUpload, Blobs: plannedBlobs(blobs)}
+	for _, b := range plan.Blobs {
+		lp := localPath(path.Join(p.root, b.Paths[0]))
+		if info, err := os.Stat(lp); err == nil {
+			b.Size = info.Size()
+		}
+
+		exists, err := p.index.hasBlob(b.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case exists:
+			b.Action = PlanSkip
+		case b.Size < 0:
+			b.Action = PlanMissing
+		default:
+			b.Action = PlanUpload
+		}
+	}
+	return plan, nil
+}
+
+// Plans a download of the files selected by f (all, if nil): blobs already
+// present locally (with the right checksum) are skipped, and blobs not in
+// the blobstore are missing.
+func (p *Pack) DownloadPlan(f *PathFilter) (*TransferPlan, error) {
+	blobs, err := p.selectedBlobs(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// (the cache is not written: planning performs no writes.)
+	cache := openHashCache(p.root)
+
+	sizes := p.manifest.HashSizes()
+	plan := &TransferPlan{Direction: PlanDownload, Blobs: plannedBlobs(blobs)}
+	for _, b := range plan.Blobs {
+		if n, found := sizes[b.Hash]; found {
+			b.Size = n
+		}
+
+		if len(upToDate(b.pathHashes(), cache)) == len(b.Paths) {
+			b.Action = PlanSkip
+			continue
+		}
+
+		exists, err := p.index.hasBlob(b.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			b.Action = PlanMissing
+			continue
+		}
+
+		b.Action = PlanDownload
+		if b.Size < 0 {
+			if n, err := p.index.blobSize(b.Hash); err == nil {
+				b.Size = n
+			}
+		}
+	}
+	return plan, nil
+}
+
+func (b *PlannedBlob) pathHashes() blobPaths {
+	blobs := blobPaths{}
+	for _, p := range b.Paths {
+		blobs[p] = b.Hash
+	}
+	return blobs
+}
+
+// Prints the plan: one line per blob, then totals.
+func (plan *TransferPlan) Print() {
+	counts := map[string]int{}
+	sizes := map[string]int64{}
+	unknown := map[string]bool{}
+
+	for _, b := range plan.Blobs {
+		size := "?"
+		if b.Size >= 0 {
+			size = humanBytes(b.Size)
+			sizes[b.Action] += b.Size
+		} else {
+			unknown[b.Action] = true
+		}
+		counts[b.Action]++
+
+		more := ""
+		if len(b.Paths) > 1 {
+			more = fmt.Sprintf(" (+%d copies)", len(b.Paths)-1)
+		}
+		pOut("%-8s  %10s  %.7s  %s%s\n", b.Action, size, b.Hash, b.Paths[0],
+			more)
+	}
+
+	total := func(action string) string {
+		s := humanBytes(sizes[action])
+		if unknown[action] {
+			s += "+"
+		}
+		return s
+	}
+
+	pOut("\n%d to %s (%s), %d skipped (%s), %d missing.\n",
+		counts[plan.Direction], plan.Direction, total(plan.Direction),
+		counts[PlanSkip], total(PlanSkip), counts[PlanMissing])
+}
+
+// Number of blobs with action.
+func (plan *TransferPlan) Count(action string) int {
+	n := 0
+	for _, b := range plan.Blobs {
+		if b.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Prints what Upload would do, without uploading.
+func (p *Pack) UploadDryRun() error {
+	if !p.manifest.Complete() {
+		return fmt.Errorf(ManifestIncompleteMsg)
+	}
+
+	plan, err := p.UploadPlan()
+	if err != nil {
+		return err
+	}
+
+	pOut("data pack: upload plan (dry run, nothing uploaded).\n")
+	plan.Print()
+	return nil
+}
+
+// Prints what Download would do, without downloading.
+func (p *Pack) DownloadDryRun(f *PathFilter) error {
+	if !p.manifest.Complete() {
+		return fmt.Errorf(`Manifest incomplete. Get new manifest copy.`)
+	}
+
+	if err := p.manifest.ValidatePaths(); err != nil {
+		return err
+	}
+
+	plan, err := p.DownloadPlan(f)
+	if err != nil {
+		return err
+	}
+
+	pOut("data pack: download plan (dry run, nothing downloaded).\n")
+	plan.Print()
+	return nil
+}
+
+// Prints what Publish would do, without publishing: blobs that must be
+// uploaded first, and whether the version would be published.
+func (p *Pack) PublishDryRun(force bool) error {
+	if err := p.checkPublishable(); err != nil {
+		return err
+	}
+
+	plan, err := p.UploadPlan()
+	if err != nil {
+		return err
+	}
+
+	pOut("data pack: publish plan (dry run, nothing published).\n")
+	plan.Print()
+
+	mfh, err := p.manifest.ManifestHash()
+	if err != nil {
+		return err
+	}
+
+	h := p.datafile.Handle()
+	ref, err := p.publishedRef(h)
+	if err != nil {
+		return err
+	}
+
+	pOut("\n")
+	switch {
+	case ref == mfh:
+		pOut(PublishedVersionSameMsg, h.Version, ref)
+	case len(ref) > 0 && !force:
+		pOut("Would fail: version %s already published as %.7s "+
+			"(use --force to overwrite).\n", h.Version, ref)
+	case plan.Count(PlanSkip) < len(plan.Blobs):
+		pOut("Would fail: objects must be uploaded first. " +
+			"Run 'data pack upload'.\n")
+	default:
+		pOut("Would publish %s (%.7s).\n", h.Dataset(), mfh)
+	}
+	return nil
+}
@@ -22,13 +22,38 @@ import (
 var Debug bool
 var NotImplementedError = fmt.Errorf("Error: not implemented yet.")
 
+// Where messages and progress are printed.
+var stdout, stderr io.Writer = os.Stdout, os.Stderr
+
+// Sets where messages (out) and progress (err) are printed. Applies to
+// the whole process; call it before using the package. (Clients print to
+// their own Output, see ClientConfig.)
+func SetOutput(out io.Writer, err io.Writer) {
+	stdout, stderr = out, err
+}
+
 // Shorthand printing functions.
 func pErr(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, a...)
+	fmt.Fprintf(stderr, format, a...)
 }
 
 func pOut(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stdout, format, a...)
+	fmt.Fprintf(stdout, format, a...)
+}
+
+// Print to w, or, if nil, as pErr and pOut do.
+func wErr(w io.Writer, format string, a ...interface{}) {
+	if w == nil {
+		w = stderr
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+func wOut(w io.Writer, format string, a ...interface{}) {
+	if w == nil {
+		w = stdout
+	}
+	fmt.Fprintf(w, format, a...)
 }
 
 func dErr(format string, a ...interface{}) {
@@ -58,14 +83,23 @@ func VersionLess(i, j string) bool {
 		return vi.LessThan(vj)
 	}
 
+	// Nope. Attempt a looser, semver-like comparison, so that
+	// "1.8" < "1.10" (semver expects three numbers exactly).
+	li, erri := parseLooseVersion(i)
+	lj, errj := parseLooseVersion(j)
+	if erri == nil && errj == nil {
+		return li.Compare(lj) < 0
+	}
+
 	// Nope. Compare lexicographically. Gross.
-	// (There should perhaps be a looser, semver-like comparison,
-	// which attempts to compare any number of dot-separated ints.
-	// Semver expects three exactly, but perhaps we want to compare
-	// "1.8" < "1.10"
 	return i < j
 }
 
+// Whether versions i and j are equal (e.g. 1.0 and 1.0.0).
+func versionEqual(i, j string) bool {
+	return !VersionLess(i, j) && !VersionLess(j, i)
+}
+
 // Checks whether string is a hash (sha1)
 func IsHash(hash string) bool {
 	if len(hash) != 40 {
@@ -81,6 +115,21 @@ func IsHash(hash string) bool {
 	return true
 }
 
+// human-readable byte size
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func shortHash(hash string) string {
 	return hash[:7]
 }
@@ -287,27 +336,10 @@ func extractArchive(filename string) error {
 	}
 	defer file.Close()
 
+	// (strips the top-level directory, see archive.go)
 	dst := strings.TrimSuffix(filename, ArchiveSuffix)
-	err = os.MkdirAll(dst, 0777)
-	if err != nil {
-		return err
-	}
-
-	dst = path.Base(dst)
-	src := path.Base(filename)
-	cmd := exec.Command("tar", "xzf", src, "--strip-components", "1", "-C", dst)
-	cmd.Dir = path.Dir(filename)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		outs := string(out)
-		if strings.Contains(outs, "Error opening archive:") {
-			return fmt.Errorf(outs)
-		}
-
-		return err
-	}
-
-	return nil
+	_, err = extractArchiveTo(file, dst)
+	return err
 }
 
 // Input
@@ -0,0 +1,297 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version ranges, as used in dependencies: <author>/<name>@<range>
+//
+// Versions are compared loosely, semver-style: up to three dot-separated
+// numbers (missing ones are 0), and an optional -prerelease suffix, so
+// "1.8" < "1.10" and "1.0" == "1.0.0". Ranges are (a subset of) npm's:
+//
+//   (empty), *, latest   any version
+//   1.2.3, =1.2.3        exactly that version
+//   >1.2, >=1.2, <2, <=2 comparisons
+//   ^1.2                 compatible: >=1.2.0 <2.0.0 (^0.2: >=0.2.0 <0.3.0)
+//   ~1.2                 patch updates: >=1.2.0 <1.3.0
+//   1.x, 1.2.x           wildcards: >=1.0.0 <2.0.0, >=1.2.0 <1.3.0
+//
+// Comparators separated by spaces must all match; alternatives are
+// separated by ||. Versions that are not numeric (e.g. "beta") only match
+// exactly. As in npm, prereleases (1.2.0-beta) only match ranges that
+// name a prerelease of the same version (>=1.2.0-alpha), so that ranges
+// do not select them by accident.
+
+type looseVersion struct {
+	Nums [3]int
+	Pre  string
+}
+
+// Parses a loose semver version: 1, 1.2, 1.2.3, with optional -pre.
+func parseLooseVersion(s string) (*looseVersion, error) {
+	s = strings.TrimPrefix(s, "v")
+	v := &looseVersion{}
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, v.Pre = s[:i], s[i+1:]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || len(s) == 0 {
+		return nil, fmt.Errorf("invalid version: %s", s)
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version: %s", s)
+		}
+		v.Nums[i] = n
+	}
+	return v, nil
+}
+
+// Returns -1, 0, or 1 if v is less than, equal to, or greater than o.
+// (Prereleases are less than their release.)
+func (v *looseVersion) Compare(o *looseVersion) int {
+	for i := 0; i < 3; i++ {
+		switch {
+		case v.Nums[i] < o.Nums[i]:
+			return -1
+		case v.Nums[i] > o.Nums[i]:
+			return 1
+		}
+	}
+
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	return comparePrerelease(v.Pre, o.Pre)
+}
+
+// Compares prereleases as semver does: by dot-separated identifiers,
+// numeric ones numerically (and before others), so beta.2 < beta.10.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil && berr != nil:
+			return -1
+		case aerr != nil && berr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		case as[i] > bs[i]:
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+type versionComparator struct {
+	Op      string // one of = > >= < <=
+	Version string
+	v       *looseVersion // nil if Version is not numeric.
+}
+
+func (c *versionComparator) Match(version string) bool {
+	if c.v == nil {
+		return c.Op == "=" && version == c.Version
+	}
+
+	v, err := parseLooseVersion(version)
+	if err != nil {
+		return false
+	}
+
+	cmp := v.Compare(c.v)
+	switch c.Op {
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+type VersionRange struct {
+	Range string
+	alts  [][]*versionComparator // any alternative, all comparators.
+}
+
+// Parses a version range (see above).
+func ParseVersionRange(s string) (*VersionRange, error) {
+	r := &VersionRange{Range: strings.TrimSpace(s)}
+	if r.Any() {
+		return r, nil
+	}
+
+	for _, alt := range strings.Split(r.Range, "||") {
+		terms := strings.Fields(alt)
+		if len(terms) == 0 {
+			return nil, fmt.Errorf("invalid version range %q", s)
+		}
+
+		comps := []*versionComparator{}
+		for _, t := range terms {
+			c, err := parseComparators(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version range %q: %s", s, err)
+			}
+			comps = append(comps, c...)
+		}
+		r.alts = append(r.alts, comps)
+	}
+	return r, nil
+}
+
+// Parses one range term into comparators.
+func parseComparators(s string) ([]*versionComparator, error) {
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, o) {
+			op, s = o, s[len(o):]
+			break
+		}
+	}
+
+	if !IdentRegexp.MatchString(strings.Replace(s, "*", "x", -1)) {
+		return nil, fmt.Errorf("invalid version %q", s)
+	}
+
+	// wildcards: 1.x, 1.2.*
+	parts := strings.Split(s, ".")
+	wild := len(parts)
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			wild = i
+			break
+		}
+	}
+	if wild < len(parts) && (len(op) == 0 || op == "=") {
+		if wild == 0 {
+			return []*versionComparator{}, nil // any
+		}
+		s = strings.Join(parts[:wild], ".")
+		op = "~"
+		if wild == 1 {
+			op = "^"
+		}
+	}
+
+	v, err := parseLooseVersion(s)
+	if err != nil {
+		// non-numeric versions only match exactly.
+		if len(op) == 0 || op == "=" {
+			return []*versionComparator{{"=", s, nil}}, nil
+		}
+		return nil, err
+	}
+
+	lower := &versionComparator{">=", s, v}
+	switch op {
+	case "", "=":
+		return []*versionComparator{{"=", s, v}}, nil
+
+	case "^":
+		// bump the first non-zero number given (or the last given).
+		n := len(strings.Split(strings.Split(s, "-")[0], "."))
+		i := 0
+		for i < n-1 && v.Nums[i] == 0 {
+			i++
+		}
+		return []*versionComparator{lower, upperBound(v, i)}, nil
+
+	case "~":
+		i := 1
+		if len(strings.Split(s, ".")) == 1 {
+			i = 0
+		}
+		return []*versionComparator{lower, upperBound(v, i)}, nil
+	}
+	return []*versionComparator{{op, s, v}}, nil
+}
+
+// Returns < (v with number i incremented, and later ones zeroed).
+func upperBound(v *looseVersion, i int) *versionComparator {
+	u := &looseVersion{}
+	copy(u.Nums[:i], v.Nums[:i])
+	u.Nums[i] = v.Nums[i] + 1
+	s := fmt.Sprintf("%d.%d.%d", u.Nums[0], u.Nums[1], u.Nums[2])
+	return &versionComparator{"<", s, u}
+}
+
+// Whether the range matches any version.
+func (r *VersionRange) Any() bool {
+	switch r.Range {
+	case "", "*", RefLatest:
+		return true
+	}
+	return false
+}
+
+// Whether version is in the range.
+func (r *VersionRange) Match(version string) bool {
+	if r.Any() {
+		return true
+	}
+
+	v, _ := parseLooseVersion(version)
+	for _, alt := range r.alts {
+		all := true
+		for _, c := range alt {
+			if !c.Match(version) {
+				all = false
+				break
+			}
+		}
+		if all && (v == nil || len(v.Pre) == 0 || allowsPrerelease(alt, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether comparators name a prerelease of v's version.
+func allowsPrerelease(comps []*versionComparator, v *looseVersion) bool {
+	for _, c := range comps {
+		if c.v != nil && len(c.v.Pre) > 0 && c.v.Nums == v.Nums {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VersionRange) String() string {
+	if r.Any() {
+		return "*"
+	}
+	return r.Range
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func comparatorsString(comps []*versionComparator) string {
+	s := []string{}
+	for _, c := range comps {
+		s = append(s, c.Op+c.Version)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestParseComparators(t *testing.T) {
+	tests := []struct {
+		term     string
+		expected string // "" for any version, "error" if invalid
+	}{
+		{"1.2.3", "=1.2.3"},
+		{"=1.2", "=1.2"},
+		{">1.2", ">1.2"},
+		{">=1.2", ">=1.2"},
+		{"<2", "<2"},
+		{"<=2.0.1", "<=2.0.1"},
+		{"^1.2.3", ">=1.2.3 <2.0.0"},
+		{"^0.2", ">=0.2 <0.3.0"},
+		{"^0.2.3", ">=0.2.3 <0.3.0"},
+		{"^0.0.3", ">=0.0.3 <0.0.4"},
+		{"^0", ">=0 <1.0.0"},
+		{"^1.2.3-beta", ">=1.2.3-beta <2.0.0"},
+		{"~1", ">=1 <2.0.0"},
+		{"~1.2", ">=1.2 <1.3.0"},
+		{"~1.2.3", ">=1.2.3 <1.3.0"},
+		{"1.x", ">=1 <2.0.0"},
+		{"1.X", ">=1 <2.0.0"},
+		{"1.2.*", ">=1.2 <1.3.0"},
+		{"1.2.x", ">=1.2 <1.3.0"},
+		{"x", ""},
+		{"*", ""},
+		{"beta", "=beta"},
+		{"=beta", "=beta"},
+		{"1.2.3.4", "=1.2.3.4"},
+		{">beta", "error"},
+		{"^beta", "error"},
+		{"~x.1", "error"},
+		{"1.2!", "error"},
+	}
+
+	for _, tt := range tests {
+		comps, err := parseComparators(tt.term)
+		got := comparatorsString(comps)
+		if err != nil {
+			got = "error"
+		}
+
+		if got != tt.expected {
+			t.Errorf("%q: %q, expected %q (%v)", tt.term, got, tt.expected, err)
+		}
+	}
+}
+
+func TestVersionRangeMatch(t *testing.T) {
+	tests := []struct {
+		rng      string
+		versions string // matching
+		others   string // not matching
+	}{
+		{"", "1.0 2.0-beta beta", ""},
+		{"*", "1.0 2.0-beta", ""},
+		{"1.2", "1.2 1.2.0 v1.2", "1.2.1 1.3"},
+		{"^1.2", "1.2 1.2.5 1.9", "1.1 2.0 2.0.0-beta 1.5.0-beta"},
+		{"^0.0.3", "0.0.3", "0.0.4 0.1.0 0.0.2"},
+		{"~1", "1.0 1.9.9", "2.0 0.9"},
+		{"1.x", "1.0 1.9", "2.0 0.9"},
+		{">=1.2 <2 || 3.x", "1.2 1.9.9 3.0 3.5", "1.1 2.0 2.5 4.0"},
+		{">=1.0 <2.0", "1.0 1.5", "2.0.0-beta 1.5.0-rc.1 2.0"},
+		{">=1.2.0-alpha", "1.2.0-alpha 1.2.0-beta 1.2.0 1.3.0",
+			"1.1.9 1.3.0-beta 1.2.0-0"},
+		{"^1.2.3-beta.2", "1.2.3-beta.2 1.2.3-beta.10 1.2.3 1.4.0",
+			"1.2.3-beta.1 1.2.3-alpha 1.4.0-beta 2.0.0"},
+		{"beta || 1.x", "beta 1.5", "gamma 2.0 1.5-beta"},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseVersionRange(tt.rng)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.rng, err)
+		}
+
+		for _, v := range strings.Fields(tt.versions) {
+			if !r.Match(v) {
+				t.Errorf("%q does not match %s", tt.rng, v)
+			}
+		}
+		for _, v := range strings.Fields(tt.others) {
+			if r.Match(v) {
+				t.Errorf("%q matches %s", tt.rng, v)
+			}
+		}
+	}
+
+	for _, rng := range []string{"||", "1.0 ||", ">beta", "^1.2 <x!"} {
+		if _, err := ParseVersionRange(rng); err == nil {
+			t.Errorf("%q: invalid range parsed", rng)
+		}
+	}
+}
+
+func TestLooseVersionCompare(t *testing.T) {
+	// in increasing order; versions in one group are equal.
+	ordered := [][]string{
+		{"0.9"},
+		{"1", "1.0", "1.0.0", "v1.0"},
+		{"1.0.1-0"},
+		{"1.0.1-1"},
+		{"1.0.1-2"},
+		{"1.0.1-10"},
+		{"1.0.1-alpha"},
+		{"1.0.1-alpha.1"},
+		{"1.0.1-alpha.beta"},
+		{"1.0.1-beta"},
+		{"1.0.1-beta.2"},
+		{"1.0.1-beta.10"},
+		{"1.0.1-rc.1"},
+		{"1.0.1"},
+		{"1.2"},
+		{"1.10"},
+		{"2.0.0-alpha"},
+		{"2.0.0"},
+	}
+
+	for i, group := range ordered {
+		for j, other := range ordered {
+			for _, a := range group {
+				for _, b := range other {
+					va, err := parseLooseVersion(a)
+					if err != nil {
+						t.Fatal(err)
+					}
+					vb, err := parseLooseVersion(b)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					expected := 0
+					if i < j {
+						expected = -1
+					} else if i > j {
+						expected = 1
+					}
+					if c := va.Compare(vb); c != expected {
+						t.Errorf("%s vs %s: %d, expected %d", a, b, c, expected)
+					}
+				}
+			}
+		}
+	}
+
+	for _, v := range []string{"", "a", "1.2.3.4", "1.-2", "1..2"} {
+		if _, err := parseLooseVersion(v); err == nil {
+			t.Errorf("%q: invalid version parsed", v)
+		}
+	}
+}